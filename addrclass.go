@@ -0,0 +1,61 @@
+package main
+
+import "net"
+
+// 地址分类，用于解释路径上那些“奇怪的 10.x 跳”
+const (
+	classPrivate   = "private"    // RFC 1918 私有地址
+	classCGNAT     = "cgnat"      // RFC 6598 运营商级NAT共享地址
+	classLinkLocal = "link-local" // 169.254.0.0/16
+	classLoopback  = "loopback"   // 127.0.0.0/8
+	classBogon     = "bogon"      // 其他不应出现在公网上的保留地址
+)
+
+// classDescriptions 是各地址分类在终端上的说明
+var classDescriptions = map[string]string{
+	classPrivate:   "私有地址",
+	classCGNAT:     "CGNAT 共享地址",
+	classLinkLocal: "链路本地地址",
+	classLoopback:  "回环地址",
+	classBogon:     "保留地址",
+}
+
+// addrRanges 按顺序列出需要标注的地址段
+var addrRanges = []struct {
+	net   *net.IPNet
+	class string
+}{
+	{cidr("10.0.0.0/8"), classPrivate},
+	{cidr("172.16.0.0/12"), classPrivate},
+	{cidr("192.168.0.0/16"), classPrivate},
+	{cidr("100.64.0.0/10"), classCGNAT},
+	{cidr("169.254.0.0/16"), classLinkLocal},
+	{cidr("127.0.0.0/8"), classLoopback},
+	{cidr("0.0.0.0/8"), classBogon},       // “本网络”
+	{cidr("192.0.0.0/24"), classBogon},    // IETF 协议分配
+	{cidr("192.0.2.0/24"), classBogon},    // TEST-NET-1 文档地址
+	{cidr("198.18.0.0/15"), classBogon},   // 网络设备基准测试
+	{cidr("198.51.100.0/24"), classBogon}, // TEST-NET-2 文档地址
+	{cidr("203.0.113.0/24"), classBogon},  // TEST-NET-3 文档地址
+	{cidr("224.0.0.0/4"), classBogon},     // 组播
+	{reservedNet, classBogon},             // 240.0.0.0/4 保留地址
+}
+
+// cidr 解析一个编译时已知正确的地址段
+func cidr(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// classifyAddr 返回地址所属的特殊地址段，普通的公网地址返回空字符串
+func classifyAddr(ip net.IP) string {
+	for _, r := range addrRanges {
+		if r.net.Contains(ip) {
+			return r.class
+		}
+	}
+	return ""
+}
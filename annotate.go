@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// annotateTimeout 是等待 --annotate-cmd 返回的最长时间
+const annotateTimeout = 30 * time.Second
+
+// annotator 调用用户提供的外部程序给每一跳加上标注，
+// 例如查询内部的 CMDB 或 IPAM 得到设备名、机房、负责人，而不必为每个系统单独做集成。
+//
+// 整个路径只调用一次程序：标准输入是所有回应者地址组成的 JSON 数组，
+// 标准输出应当是一个 JSON 对象，把地址映射到任意数量的标注字符串，例如
+//
+//	["192.0.2.1","198.51.100.7"]
+//	{"192.0.2.1":["core-sw-01","机房A"]}
+//
+// 没有标注的地址可以不出现在输出中。
+type annotator struct {
+	args []string
+}
+
+// newAnnotator 解析 --annotate-cmd 的值（程序及其参数，以空白分隔），并确认程序存在
+func newAnnotator(cmdline string) (*annotator, error) {
+	args := strings.Fields(cmdline)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("--annotate-cmd 为空")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, fmt.Errorf("找不到标注程序: %w", err)
+	}
+	return &annotator{args: args}, nil
+}
+
+// annotate 把结果中出现的所有地址交给外部程序，把返回的标注记到对应的跳上
+func (a *annotator) annotate(result *TraceResult) error {
+	var addrs []string
+	seen := make(map[string]bool)
+	add := func(addr string) {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, hop := range result.Hops {
+		add(hop.Addr)
+		for _, r := range hop.Responders {
+			add(r)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	input, err := json.Marshal(addrs)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), annotateTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, a.args[0], a.args[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("运行标注程序失败: %w", err)
+	}
+	var labels map[string][]string
+	if err := json.Unmarshal(output, &labels); err != nil {
+		return fmt.Errorf("解析标注程序的输出失败: %w", err)
+	}
+	for i := range result.Hops {
+		result.Hops[i].Labels = labels[result.Hops[i].Addr]
+	}
+	return nil
+}
+
+// reportAnnotations 打印外部程序给出的标注
+func reportAnnotations(result *TraceResult) {
+	printed := false
+	for _, hop := range result.Hops {
+		if len(hop.Labels) == 0 {
+			continue
+		}
+		if !printed {
+			fmt.Println("外部标注:")
+			printed = true
+		}
+		fmt.Printf("%2d %-15s %s\n", hop.TTL, anon.addr(hop.Addr), strings.Join(anon.labels(hop.Labels), ", "))
+	}
+}
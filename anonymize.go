@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// anon 在 --anonymize 时把输出中的地址替换为假名，为 nil 时原样输出。
+// 所有打印和导出地址的地方都经过它，探测和分析过程仍然使用真实地址。
+var anon *anonymizer
+
+// redacted 替换被隐去的主机名
+const redacted = "[已隐去]"
+
+// anonymizer 用 Crypto-PAn 算法对IPv4地址做保持前缀的假名化：
+// 两个真实地址有多长的公共前缀，它们的假名就有多长的公共前缀，
+// 因此输出中仍能看出哪些跳属于同一个网段，但看不出真实的编址。
+// 同一个密钥下同一个地址总是得到同一个假名。AS 号同样换成假名，地理坐标只保留到整数度。
+type anonymizer struct {
+	block cipher.Block
+	pad   [aes.BlockSize]byte
+}
+
+// newAnonymizer 用 key 派生 Crypto-PAn 需要的 32 字节密钥。
+// key 为空时使用随机密钥，假名只在本次运行内保持一致。
+func newAnonymizer(key string) (*anonymizer, error) {
+	var secret [32]byte
+	if key == "" {
+		if _, err := rand.Read(secret[:]); err != nil {
+			return nil, err
+		}
+	} else {
+		secret = sha256.Sum256([]byte(key))
+	}
+	// 前 16 字节是 AES 密钥，后 16 字节加密之后作为填充
+	block, err := aes.NewCipher(secret[:16])
+	if err != nil {
+		return nil, err
+	}
+	a := &anonymizer{block: block}
+	block.Encrypt(a.pad[:], secret[16:])
+	return a, nil
+}
+
+// addr 返回地址的假名。不是IPv4地址的字符串（例如主机名形式的目标）整个隐去。
+func (a *anonymizer) addr(s string) string {
+	if a == nil || s == "" {
+		return s
+	}
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return redacted
+	}
+	return a.ipv4(ip).String()
+}
+
+// host 隐去反向解析得到的主机名
+func (a *anonymizer) host(s string) string {
+	if a == nil || s == "" {
+		return s
+	}
+	return redacted
+}
+
+// ipv4 是 Crypto-PAn 的核心：假名的第 i 位等于原地址第 i 位异或一个伪随机位，
+// 而这个伪随机位只由原地址的前 i 位决定，这就保证了前缀关系不变。
+func (a *anonymizer) ipv4(ip net.IP) net.IP {
+	orig := binary.BigEndian.Uint32(ip)
+	padHead := binary.BigEndian.Uint32(a.pad[:4])
+	var in, out [aes.BlockSize]byte
+	copy(in[4:], a.pad[4:])
+	var otp uint32
+	for i := 0; i < 32; i++ {
+		// 前 i 位取自原地址，其余位取自填充
+		mask := ^uint32(0) << (32 - i)
+		binary.BigEndian.PutUint32(in[:4], orig&mask|padHead&^mask)
+		a.block.Encrypt(out[:], in[:])
+		otp |= uint32(out[0]>>7) << (31 - i)
+	}
+	pseudo := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(pseudo, orig^otp)
+	return pseudo
+}
+
+// prefix 返回网段的假名：网络地址取假名后按原来的长度截断。
+// Crypto-PAn 保持前缀，同一网段内所有地址的假名都落在这个假名网段中。
+func (a *anonymizer) prefix(s string) string {
+	if a == nil || s == "" {
+		return s
+	}
+	ip, n, err := net.ParseCIDR(s)
+	if err != nil || ip.To4() == nil {
+		return redacted
+	}
+	ones, _ := n.Mask.Size()
+	return a.ipv4(n.IP.To4()).Mask(n.Mask).String() + "/" + strconv.Itoa(ones)
+}
+
+// 假名 AS 号取自 RFC 6996 保留给私有用途的 32 位 AS 号段，不会和真实的 AS 号混淆
+const (
+	privateASNFirst uint32 = 4200000000
+	privateASNLast  uint32 = 4294967294
+)
+
+// asn 返回 AS 号的假名。同一个密钥下同一个 AS 号总是得到同一个假名，
+// 所以仍能看出 AS 边界和路径经过了几个 AS，但看不出是哪家运营商。0 表示没有查到，原样保留。
+func (a *anonymizer) asn(n int) int {
+	if a == nil || n == 0 {
+		return n
+	}
+	// 输入块的后 12 字节取自填充并翻转首字节，与 ipv4 使用的输入区分开
+	var in, out [aes.BlockSize]byte
+	copy(in[4:], a.pad[4:])
+	in[4] ^= 0xff
+	binary.BigEndian.PutUint32(in[:4], uint32(n))
+	a.block.Encrypt(out[:], in[:])
+	return int(privateASNFirst + binary.BigEndian.Uint32(out[:4])%(privateASNLast-privateASNFirst+1))
+}
+
+// coord 把经纬度舍入到整数度（赤道附近约 110 公里），只保留大致的地区
+func (a *anonymizer) coord(v float64) float64 {
+	if a == nil {
+		return v
+	}
+	return math.Round(v)
+}
+
+// ipv4Literal 匹配文字中的IPv4地址
+var ipv4Literal = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+
+// text 处理错误信息之类的自由文字：其中的IPv4地址换成假名，names 中的主机名隐去。
+// 反向解析的 in-addr.arpa 名字中倒序的地址同样会被替换，不会泄露真实地址。
+func (a *anonymizer) text(s string, names ...string) string {
+	if a == nil || s == "" {
+		return s
+	}
+	for _, name := range names {
+		if name != "" && net.ParseIP(name) == nil {
+			s = strings.ReplaceAll(s, name, redacted)
+		}
+	}
+	return ipv4Literal.ReplaceAllStringFunc(s, func(m string) string {
+		if ip := net.ParseIP(m).To4(); ip != nil {
+			return a.ipv4(ip).String()
+		}
+		return m
+	})
+}
+
+// mac 只保留 MAC 地址中表示厂商的前三个字节，隐去具体设备的部分
+func (a *anonymizer) mac(s string) string {
+	if a == nil || len(s) != len("00:00:00:00:00:00") {
+		return s
+	}
+	return s[:8] + ":xx:xx:xx"
+}
+
+// labels 隐去外部程序给出的标注，它们通常是内部的设备名或机房名
+func (a *anonymizer) labels(list []string) []string {
+	if a == nil || list == nil {
+		return list
+	}
+	out := make([]string, len(list))
+	for i, s := range list {
+		out[i] = a.host(s)
+	}
+	return out
+}
+
+// result 返回结果的一个假名化副本，用于保存和投递 webhook
+func (a *anonymizer) result(r *TraceResult) *TraceResult {
+	if a == nil || r == nil {
+		return r
+	}
+	// 借助 JSON 做一次深拷贝，原结果保持不变
+	var out TraceResult
+	body, _ := json.Marshal(r)
+	json.Unmarshal(body, &out)
+
+	for i := range out.Errors {
+		out.Errors[i].Message = a.text(out.Errors[i].Message, out.Target, out.TargetASCII)
+	}
+	if out.Connect != nil {
+		out.Connect.Error = a.text(out.Connect.Error)
+	}
+	out.Target = a.addr(out.Target)
+	out.TargetASCII = a.addr(out.TargetASCII)
+	out.DestIP = a.addr(out.DestIP)
+	if out.Egress != nil {
+		out.Egress.Source = a.addr(out.Egress.Source)
+		out.Egress.Gateway = a.addr(out.Egress.Gateway)
+		out.Egress.GatewayMAC = a.mac(out.Egress.GatewayMAC)
+	}
+	for i := range out.Hops {
+		a.replaceHop(&out.Hops[i])
+	}
+	out.Loop = a.addrs(out.Loop)
+	for i := range out.LateReplies {
+		out.LateReplies[i].Addr = a.addr(out.LateReplies[i].Addr)
+	}
+	out.Reverse = a.result(r.Reverse)
+	return &out
+}
+
+// hop 返回一跳的假名化副本，用于逐跳发布的输出
+func (a *anonymizer) hop(h *Hop) *Hop {
+	if a == nil || h == nil {
+		return h
+	}
+	var out Hop
+	body, _ := json.Marshal(h)
+	json.Unmarshal(body, &out)
+	a.replaceHop(&out)
+	return &out
+}
+
+// replaceHop 就地替换一跳中的地址、主机名、AS 号、位置和标注，调用者负责先做拷贝
+func (a *anonymizer) replaceHop(hop *Hop) {
+	hop.Addr = a.addr(hop.Addr)
+	hop.Host = a.host(hop.Host)
+	hop.Prefix = a.prefix(hop.Prefix)
+	hop.ASN = a.asn(hop.ASN)
+	hop.Responders = a.addrs(hop.Responders)
+	hop.Labels = a.labels(hop.Labels)
+	if hop.IPAM != nil {
+		hop.IPAM.Label, hop.IPAM.Site, hop.IPAM.Owner = a.host(hop.IPAM.Label), a.host(hop.IPAM.Site), a.host(hop.IPAM.Owner)
+	}
+	if hop.Geo != nil {
+		hop.Geo.Label = a.host(hop.Geo.Label)
+		hop.Geo.Lat, hop.Geo.Lon = a.coord(hop.Geo.Lat), a.coord(hop.Geo.Lon)
+	}
+	for j := range hop.Probes {
+		hop.Probes[j].Addr = a.addr(hop.Probes[j].Addr)
+	}
+}
+
+// addrs 对一组地址分别取假名
+func (a *anonymizer) addrs(list []string) []string {
+	if a == nil || list == nil {
+		return list
+	}
+	out := make([]string, len(list))
+	for i, s := range list {
+		out[i] = a.addr(s)
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math/bits"
+	"net"
+	"testing"
+)
+
+// commonPrefix 返回两个IPv4地址相同的前导位数
+func commonPrefix(a, b string) int {
+	x, y := net.ParseIP(a).To4(), net.ParseIP(b).To4()
+	return bits.LeadingZeros32(uint32(x[0]^y[0])<<24 | uint32(x[1]^y[1])<<16 | uint32(x[2]^y[2])<<8 | uint32(x[3]^y[3]))
+}
+
+func mustAnonymizer(t *testing.T, key string) *anonymizer {
+	t.Helper()
+	a, err := newAnonymizer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAnonymizePrefixPreserving(t *testing.T) {
+	a := mustAnonymizer(t, "test-key")
+	addrs := []string{
+		"10.0.0.1", "10.0.0.2", "10.0.1.1", "10.1.0.1", "11.0.0.1",
+		"192.168.1.1", "192.168.1.254", "192.168.2.1", "8.8.8.8", "8.8.4.4",
+		"0.0.0.0", "255.255.255.255", "128.0.0.0", "127.255.255.255",
+	}
+	for _, x := range addrs {
+		for _, y := range addrs {
+			px, py := a.addr(x), a.addr(y)
+			if got, want := commonPrefix(px, py), commonPrefix(x, y); got != want {
+				t.Errorf("%s 和 %s 的公共前缀是 %d 位，假名 %s 和 %s 是 %d 位", x, y, want, px, py, got)
+			}
+		}
+	}
+
+	// 网段的假名包含网段内地址的假名
+	_, n, _ := net.ParseCIDR(a.prefix("192.168.1.0/24"))
+	for _, s := range []string{"192.168.1.1", "192.168.1.254"} {
+		if !n.Contains(net.ParseIP(a.addr(s))) {
+			t.Errorf("%s 的假名 %s 不在网段假名 %s 中", s, a.addr(s), n)
+		}
+	}
+}
+
+func TestAnonymizeDeterministic(t *testing.T) {
+	a, b := mustAnonymizer(t, "test-key"), mustAnonymizer(t, "test-key")
+	other := mustAnonymizer(t, "other-key")
+	changed := 0
+	for _, s := range []string{"10.0.0.1", "192.168.1.1", "8.8.8.8", "203.0.113.9"} {
+		if a.addr(s) != b.addr(s) {
+			t.Errorf("相同的密钥给 %s 不同的假名: %s 和 %s", s, a.addr(s), b.addr(s))
+		}
+		if a.addr(s) == s {
+			t.Errorf("%s 的假名与原地址相同", s)
+		}
+		if a.addr(s) != other.addr(s) {
+			changed++
+		}
+	}
+	if changed == 0 {
+		t.Errorf("不同的密钥得到了完全相同的假名")
+	}
+	if a.asn(15169) != b.asn(15169) || a.asn(15169) == other.asn(15169) {
+		t.Errorf("AS 号的假名与密钥的关系不对: %d %d %d", a.asn(15169), b.asn(15169), other.asn(15169))
+	}
+}
+
+func TestAnonymizeHop(t *testing.T) {
+	a := mustAnonymizer(t, "test-key")
+	hop := &Hop{
+		TTL: 3, Addr: "203.0.113.9", Host: "core1.example.net", Prefix: "203.0.113.0/24",
+		ASN: 64500, ASBoundary: true, Labels: []string{"rack-7"},
+		Geo:  &GeoInfo{Lat: 22.3193, Lon: 114.1694, Label: "Hong Kong", DistanceKm: 1234},
+		IPAM: &IPAMInfo{Label: "edge", Site: "hkg"},
+	}
+	got := a.hop(hop)
+	if got == hop || hop.Addr != "203.0.113.9" || hop.Geo.Lat != 22.3193 {
+		t.Fatalf("hop 修改了原来的跳")
+	}
+	if got.Addr == hop.Addr || got.Host != redacted || got.Labels[0] != redacted || got.IPAM.Site != redacted {
+		t.Errorf("地址、主机名或标注没有替换: %+v", got)
+	}
+	if uint32(got.ASN) < privateASNFirst || uint32(got.ASN) > privateASNLast || got.ASN != a.asn(64500) || !got.ASBoundary {
+		t.Errorf("AS 号的假名 %d 不对", got.ASN)
+	}
+	if got.Geo.Lat != 22 || got.Geo.Lon != 114 || got.Geo.Label != redacted || got.Geo.DistanceKm != 1234 {
+		t.Errorf("位置没有舍入: %+v", got.Geo)
+	}
+	if a.asn(0) != 0 {
+		t.Errorf("没有查到的 AS 号被换成了 %d", a.asn(0))
+	}
+
+	// 没有 --anonymize 时原样输出
+	var none *anonymizer
+	if none.hop(hop) != hop || none.asn(64500) != 64500 || none.coord(22.3193) != 22.3193 {
+		t.Errorf("nil 假名化器改变了输出")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// asnResolver 通过 Team Cymru 的 DNS 接口查询地址的起源 AS。
+// 查询 <反转的地址>.origin.asn.cymru.com 的 TXT 记录，返回形如
+// "15169 | 8.8.8.0/24 | US | arin | 2014-03-14" 的文本；
+// 查询 AS<号码>.asn.cymru.com 的 TXT 记录可以得到 AS 的名字。
+type asnResolver struct {
+	names map[int]string // 已查询过的 AS 名字
+}
+
+func newASNResolver() *asnResolver {
+	return &asnResolver{names: make(map[int]string)}
+}
+
+// origin 返回宣告了该地址所在前缀的 AS 号以及该前缀，查不到时 AS 号为 0。
+// 同一前缀被多个 AS 宣告时（MOAS）取第一个。
+func (r *asnResolver) origin(ip net.IP) (asn int, prefix string) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return 0, ""
+	}
+	name := fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", ip4[3], ip4[2], ip4[1], ip4[0])
+	fields := r.lookupTXT(name)
+	if len(fields) < 2 {
+		return 0, ""
+	}
+	asns := strings.Fields(fields[0])
+	if len(asns) == 0 {
+		return 0, ""
+	}
+	asn, err := strconv.Atoi(asns[0])
+	if err != nil {
+		return 0, ""
+	}
+	return asn, fields[1]
+}
+
+// name 返回 AS 的名字，查不到时返回空字符串
+func (r *asnResolver) name(asn int) string {
+	if name, ok := r.names[asn]; ok {
+		return name
+	}
+	// 返回值形如 "15169 | US | arin | 2000-03-30 | GOOGLE, US"
+	fields := r.lookupTXT(fmt.Sprintf("AS%d.asn.cymru.com", asn))
+	name := ""
+	if len(fields) >= 5 {
+		name = fields[4]
+	}
+	r.names[asn] = name
+	return name
+}
+
+// lookupTXT 查询 TXT 记录并按 "|" 拆分第一条记录
+func (r *asnResolver) lookupTXT(name string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	txts, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if err != nil || len(txts) == 0 {
+		return nil
+	}
+	fields := strings.Split(txts[0], "|")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields
+}
+
+// markASBoundary 在新加入的一跳和它之前最近一个已知 AS 的跳属于不同的 AS 时，
+// 把它标记为 AS 边界，也就是流量从一个网络交给另一个网络的地方
+func markASBoundary(hops []Hop, hop *Hop) {
+	if hop.ASN == 0 {
+		return
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i].ASN != 0 {
+			hop.ASBoundary = hops[i].ASN != hop.ASN
+			return
+		}
+	}
+}
+
+// reportASPath 打印路径依次经过的 AS 以及交接的次数
+func reportASPath(result *TraceResult, r *asnResolver) {
+	var path []int
+	for _, hop := range result.Hops {
+		if hop.ASN != 0 && (len(path) == 0 || path[len(path)-1] != hop.ASN) {
+			path = append(path, hop.ASN)
+		}
+	}
+	if len(path) == 0 {
+		fmt.Println("AS 路径: 没有查到任何一跳的起源 AS")
+		return
+	}
+	var parts []string
+	distinct := make(map[int]bool)
+	for _, asn := range path {
+		distinct[asn] = true
+		s := fmt.Sprintf("AS%d", anon.asn(asn))
+		if name := anon.host(r.name(asn)); name != "" {
+			s += " (" + name + ")"
+		}
+		parts = append(parts, s)
+	}
+	fmt.Printf("AS 路径: %s\n", strings.Join(parts, " → "))
+	fmt.Printf("经过 %d 个 AS，交接 %d 次\n", len(distinct), len(path)-1)
+}
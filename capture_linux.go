@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// packetCapture 用 AF_PACKET 套接字在链路层接收发给本机的ICMP报文（--af-packet），
+// 代替 "ip4:icmp" 原始套接字。它实现了 net.PacketConn 中 rawProber 用到的部分，
+// ReadFrom 返回的与原始套接字相同：去掉IP头的ICMP消息和发送者地址。
+//
+// 与原始IP套接字相比：
+//   - 内核中的 BPF 过滤器直接丢掉非ICMP报文，不必经过IP层再复制给每个原始套接字；
+//   - 能知道每个回应是从哪个接口收到的，放在返回地址的 Zone 字段中；
+//   - 不经过本机的防火墙规则（raw 表之后才轮到原始套接字），被 INPUT 链丢弃的回应也能看到。
+//
+// 链路层收到的是未重组的报文，分片到达的回应无法使用，会被直接丢弃。
+type packetCapture struct {
+	f      *os.File
+	rc     syscall.RawConn
+	buf    []byte
+	ifaces map[int]string // 接口序号到名字的缓存
+}
+
+// icmpOnlyFilter 只接受协议号为ICMP的IPv4报文。
+// 套接字类型是 SOCK_DGRAM，内核已经去掉了链路层头，偏移 0 就是IP头。
+var icmpOnlyFilter = []bpf.Instruction{
+	bpf.LoadAbsolute{Off: 9, Size: 1},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: 1, SkipFalse: 1},
+	bpf.RetConstant{Val: maxPacketLen},
+	bpf.RetConstant{Val: 0},
+}
+
+// newPacketCapture 创建 AF_PACKET 套接字并挂上过滤器，需要 root 或 CAP_NET_RAW
+func newPacketCapture() (net.PacketConn, error) {
+	prog, err := bpf.Assemble(icmpOnlyFilter)
+	if err != nil {
+		return nil, err
+	}
+	filter := make([]unix.SockFilter, len(prog))
+	for i, ins := range prog {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+
+	proto := int(htons(unix.ETH_P_IP))
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	// 先挂过滤器再绑定，避免在两者之间收到无关的报文
+	fprog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &fprog); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("挂载BPF过滤器失败: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: uint16(proto)}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	// 非阻塞的描述符交给 os.File 后由 Go 的网络轮询器管理，读超时才能生效
+	f := os.NewFile(uintptr(fd), "af-packet")
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &packetCapture{f: f, rc: rc, buf: make([]byte, maxPacketLen), ifaces: make(map[int]string)}, nil
+}
+
+// ReadFrom 读取下一个发给本机的ICMP消息，把去掉IP头的ICMP部分复制到 b 中
+func (c *packetCapture) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, _, addr, err := c.readFromTTL(b)
+	return n, addr, err
+}
+
+// readFromTTL 与 ReadFrom 相同，同时返回报文IP头中的TTL
+func (c *packetCapture) readFromTTL(b []byte) (int, int, net.Addr, error) {
+	for {
+		var n int
+		var from unix.Sockaddr
+		var rerr error
+		err := c.rc.Read(func(fd uintptr) bool {
+			n, from, rerr = unix.Recvfrom(int(fd), c.buf, 0)
+			return rerr != unix.EAGAIN
+		})
+		if err == nil {
+			err = rerr
+		}
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		ll, ok := from.(*unix.SockaddrLinklayer)
+		// 本机发出的报文也会被 AF_PACKET 看到，只留下收到的
+		if !ok || ll.Pkttype == unix.PACKET_OUTGOING {
+			continue
+		}
+		pkt := c.buf[:n]
+		if len(pkt) < 20 || pkt[0]>>4 != 4 {
+			continue
+		}
+		hdrLen := int(pkt[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(pkt[2:4]))
+		// 分片（MF 标志或非零偏移）无法在这里重组
+		if hdrLen < 20 || totalLen < hdrLen || totalLen > n || binary.BigEndian.Uint16(pkt[6:8])&0x3fff != 0 {
+			continue
+		}
+		src := net.IP(append([]byte(nil), pkt[12:16]...))
+		return copy(b, pkt[hdrLen:totalLen]), int(pkt[8]), &net.IPAddr{IP: src, Zone: c.ifaceName(ll.Ifindex)}, nil
+	}
+}
+
+// ifaceName 返回接口序号对应的名字，查不到时返回序号本身
+func (c *packetCapture) ifaceName(index int) string {
+	if name, ok := c.ifaces[index]; ok {
+		return name
+	}
+	name := fmt.Sprint(index)
+	if ifi, err := net.InterfaceByIndex(index); err == nil {
+		name = ifi.Name
+	}
+	c.ifaces[index] = name
+	return name
+}
+
+func (c *packetCapture) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return 0, errors.New("AF_PACKET 接收套接字不能用来发送")
+}
+
+func (c *packetCapture) Close() error                       { return c.f.Close() }
+func (c *packetCapture) LocalAddr() net.Addr                { return &net.IPAddr{IP: net.IPv4zero} }
+func (c *packetCapture) SetDeadline(t time.Time) error      { return c.f.SetDeadline(t) }
+func (c *packetCapture) SetReadDeadline(t time.Time) error  { return c.f.SetReadDeadline(t) }
+func (c *packetCapture) SetWriteDeadline(t time.Time) error { return c.f.SetWriteDeadline(t) }
+
+// htons 把16位整数转换为网络字节序，AF_PACKET 的协议号要求这样传递：
+// 按大端写入两个字节，再按本机字节序读回来，在大端机器上就是原样返回
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
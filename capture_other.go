@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// newPacketCapture 在非 Linux 平台上不可用，AF_PACKET 是 Linux 特有的
+func newPacketCapture() (net.PacketConn, error) {
+	return nil, errors.New("--af-packet 只在 Linux 上可用")
+}
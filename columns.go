@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+)
+
+// defaultColumns 是不指定 --columns 时逐跳输出的列
+const defaultColumns = "ttl,ip,ipam,status,loss,ports,asn,rpki,class,ixp,geo,ptr,tunnel"
+
+// column 是逐跳输出中的一列
+type column struct {
+	name  string
+	width int            // 对齐宽度，正数右对齐、负数左对齐，0 表示不对齐且为空时省略
+	fill  func(hop *Hop) // 输出前补充这一列需要的数据（例如反向解析），可以为 nil
+	value func(hop *Hop) string
+}
+
+// columnSet 是所有可选的列
+var columnSet = map[string]column{
+	"ttl": {width: 2, value: func(h *Hop) string { return fmt.Sprint(h.TTL) }},
+	"ip":  {width: -15, value: func(h *Hop) string { return anon.addr(h.Addr) }},
+	"host": {width: -30, fill: lookupHost, value: func(h *Hop) string {
+		// 没有反向解析记录时和传统 traceroute 一样显示地址本身
+		if h.Host == "" {
+			return anon.addr(h.Addr)
+		}
+		return anon.host(h.Host)
+	}},
+	"ipam": {value: func(h *Hop) string {
+		if h.IPAM == nil {
+			return ""
+		}
+		return describeIPAM(h.IPAM)
+	}},
+	"status": {value: hopStatus},
+	"rtt": {value: func(h *Hop) string {
+		if h.Timeout {
+			return ""
+		}
+		return fmt.Sprintf("%.3f ms", h.RTTMs)
+	}},
+	"avg":  {value: describeAvg},
+	"loss": {value: func(h *Hop) string { return strings.TrimSpace(lossNote(h)) }},
+	"ports": {value: func(h *Hop) string {
+		if len(h.Ports) == 0 {
+			return ""
+		}
+		return "[应答端口: " + joinPorts(h.Ports) + "]"
+	}},
+	"class": {value: func(h *Hop) string {
+		if h.Class == "" {
+			return ""
+		}
+		return "[" + classDescriptions[h.Class] + "]"
+	}},
+	"asn": {value: func(h *Hop) string {
+		switch {
+		case h.ASN == 0:
+			return ""
+		case h.ASBoundary:
+			return fmt.Sprintf("[AS%d] <- AS 边界", anon.asn(h.ASN))
+		}
+		return fmt.Sprintf("[AS%d]", anon.asn(h.ASN))
+	}},
+	"rpki": {value: func(h *Hop) string {
+		// 只标出无效的宣告，有效和未覆盖的是常态，不必占用一列
+		if h.RPKI == rpkiInvalid {
+			return "[RPKI 无效: " + anon.prefix(h.Prefix) + "]"
+		}
+		return ""
+	}},
+	"ixp": {value: func(h *Hop) string {
+		if h.IXP == "" {
+			return ""
+		}
+		return "[IXP: " + h.IXP + "]"
+	}},
+	"ttls": {value: func(h *Hop) string {
+		if h.ReplyTTL == 0 {
+			return ""
+		}
+		return fmt.Sprintf("[回应 TTL %d，引用 TTL %d]", h.ReplyTTL, h.QuotedTTL)
+	}},
+	"tunnel": {value: describeTunnel},
+	"geo":    {value: describeGeo},
+	"ptr": {value: func(h *Hop) string {
+		if !h.PTRMismatch {
+			return ""
+		}
+		return "[PTR 不符: " + anon.host(h.Host) + "]"
+	}},
+}
+
+// parseColumns 解析逗号分隔的列名列表
+func parseColumns(spec string) ([]column, error) {
+	var cols []column
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		c, ok := columnSet[name]
+		if !ok {
+			names := make([]string, 0, len(columnSet))
+			for n := range columnSet {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("未知的列 %q，可用的列有: %s", name, strings.Join(names, ","))
+		}
+		c.name = name
+		cols = append(cols, c)
+	}
+	return cols, nil
+}
+
+// formatHop 按选定的列把一跳格式化成一行。
+// 超时的跳没有地址等信息，只保留TTL列并打印超时提示。
+// resolve 为 false 时跳过反向解析之类需要查询外部数据的列准备工作。
+func formatHop(cols []column, hop *Hop, resolve bool) string {
+	var parts []string
+	for _, c := range cols {
+		if hop.Timeout && c.name != "ttl" {
+			continue
+		}
+		if c.fill != nil && resolve {
+			c.fill(hop)
+		}
+		v := c.value(hop)
+		if c.width == 0 {
+			if v == "" {
+				continue
+			}
+		} else {
+			v = fmt.Sprintf("%*s", c.width, v)
+		}
+		parts = append(parts, v)
+	}
+	if hop.Timeout {
+		parts = append(parts, "* * * Request timed out.")
+	}
+	return strings.TrimRight(strings.Join(parts, " "), " ")
+}
+
+// hopStatus 返回这一跳收到的ICMP消息类型的说明，
+// 不是用UDP探测到的跳（--fallback）还会注明协议
+func hopStatus(h *Hop) string {
+	var s string
+	switch {
+	case h.Protocol == protoTCP && h.State == hopReached:
+		s = "(SYN-ACK/RST)"
+	case ipv4.ICMPType(h.ICMPType) == ipv4.ICMPTypeTimeExceeded:
+		s = "(Time Exceeded)"
+	case ipv4.ICMPType(h.ICMPType) == ipv4.ICMPTypeDestinationUnreachable && h.ICMPCode == codeFragNeeded:
+		s = "(Fragmentation Needed)"
+		if h.NextMTU > 0 {
+			s = fmt.Sprintf("(Fragmentation Needed，下一跳 MTU %d)", h.NextMTU)
+		}
+	case ipv4.ICMPType(h.ICMPType) == ipv4.ICMPTypeDestinationUnreachable:
+		s = "(Destination Unreachable)"
+	case ipv4.ICMPType(h.ICMPType) == ipv4.ICMPTypeEchoReply && h.State == hopReached:
+		s = "(Echo Reply)"
+	default:
+		s = fmt.Sprintf("(未知 ICMP 类型: %d)", h.ICMPType)
+	}
+	if h.Protocol != "" && h.Protocol != protoUDP {
+		s += " [" + strings.ToUpper(h.Protocol) + "]"
+	}
+	return s
+}
+
+// lookupHost 查出这一跳的主机名：先查 --hosts-file，再做反向解析，解析失败时保持为空
+func lookupHost(h *Hop) {
+	if h.Addr == "" || h.Host != "" {
+		return
+	}
+	if name := localHosts.lookup(h.Addr); name != "" {
+		h.Host = name
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, h.Addr)
+	if err == nil && len(names) > 0 {
+		h.Host = strings.TrimSuffix(names[0], ".")
+	}
+}
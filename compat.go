@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+
+	"udp-traceroute/icmpreply"
+)
+
+// outputCompat 按 Linux traceroute 的版式逐跳输出（--compat），
+// 让解析 traceroute 输出的脚本不用修改就能继续工作
+const outputCompat = "compat"
+
+// compatProbes 是传统 traceroute 每跳默认发送的探测包数量
+const compatProbes = 3
+
+// compatHeader 返回 traceroute 开头的那一行
+func compatHeader(target, destIP string) string {
+	return fmt.Sprintf("traceroute to %s (%s), %d hops max, %d byte packets",
+		anon.addr(target), anon.addr(destIP), maxHops, probeOverhead+probeIDLen)
+}
+
+// formatCompat 按 traceroute 的版式格式化一跳：
+// 每个探测包占一项，地址变化时先打印“名字 (地址)”，和 traceroute -n 一样不解析时只打印地址，
+// 然后是 RTT 和 !H 之类的不可达标记，超时的探测包打印 *。
+func formatCompat(hop *Hop, resolve bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%2d ", hop.TTL)
+	last := ""
+	for _, p := range hop.Probes {
+		if p.Timeout {
+			b.WriteString(" *")
+			continue
+		}
+		if p.Addr != last {
+			last = p.Addr
+			if !resolve {
+				fmt.Fprintf(&b, " %s", anon.addr(p.Addr))
+			} else {
+				// IPAM 中的名字是用户自己给的，比 PTR 记录更可信，优先使用
+				name := compatName(p.Addr)
+				if hop.IPAM != nil && p.Addr == hop.Addr {
+					name = anon.host(hop.IPAM.Label)
+				}
+				fmt.Fprintf(&b, " %s (%s)", name, anon.addr(p.Addr))
+			}
+		}
+		fmt.Fprintf(&b, "  %.3f ms", p.RTTMs)
+		if mark := icmpreply.Annotation(ipv4.ICMPType(p.ICMPType), p.ICMPCode); mark != "" {
+			b.WriteString(" " + mark)
+		}
+	}
+	return b.String()
+}
+
+// compatNames 缓存反向解析的结果，同一个地址在多个探测包中出现时只解析一次
+var compatNames = make(map[string]string)
+
+// compatName 返回地址的主机名，和 traceroute 一样解析失败时使用地址本身
+func compatName(addr string) string {
+	name, ok := compatNames[addr]
+	if !ok && localHosts.lookup(addr) != "" {
+		name, ok = localHosts.lookup(addr), true
+		compatNames[addr] = name
+	}
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		names, err := net.DefaultResolver.LookupAddr(ctx, addr)
+		cancel()
+		if err == nil && len(names) > 0 {
+			name = strings.TrimSuffix(names[0], ".")
+		}
+		compatNames[addr] = name
+	}
+	if name == "" {
+		return anon.addr(addr)
+	}
+	return anon.host(name)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// progName 是补全脚本注册的命令名，即 go build 生成的可执行文件名
+const progName = "udp-traceroute"
+
+// collectFlags 不为空时，子命令定义完选项后把 FlagSet 交给它并直接返回，
+// 这样生成补全脚本时可以拿到每个子命令真实的选项，而不必另外维护一份列表
+var collectFlags func(fs *flag.FlagSet)
+
+// flagsOnly 在子命令定义完选项、解析参数之前调用，返回 true 时子命令应当立即返回
+func flagsOnly(fs *flag.FlagSet) bool {
+	if collectFlags == nil {
+		return false
+	}
+	collectFlags(fs)
+	return true
+}
+
+// flagChoices 列出取值是固定几个之一的选项，键为“子命令.选项名”
+var flagChoices = map[string][]string{
+	"trace.output":       {outputTable, outputFlat},
+	"render.output":      {outputTable, outputFlat, outputCompat, outputQuiet, outputJSON, outputPB, outputHTML},
+	"trace.udp-checksum": checksumModes,
+	"trace.port-mode":    portModes,
+}
+
+// fileFlags 列出值为文件路径的选项，其余需要值的选项不做补全
+var fileFlags = map[string]bool{
+	"hosts-file": true,
+	"save":       true,
+	"ixp-file":   true,
+	"ipam-file":  true,
+	"geo-file":   true,
+	"oui-file":   true,
+	"rpki-vrps":  true,
+}
+
+// completionShells 是 completion 子命令支持的 shell
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// cmdFlags 是补全脚本需要的一个子命令的信息
+type cmdFlags struct {
+	command
+	flags []*flag.Flag
+}
+
+// takesValue 报告选项是否需要跟一个值，布尔选项不需要
+func takesValue(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// flagName 返回选项在命令行上的写法：单字母选项沿用 traceroute 的 -n，其余用 --name
+func flagName(f *flag.Flag) string {
+	if len(f.Name) == 1 {
+		return "-" + f.Name
+	}
+	return "--" + f.Name
+}
+
+// runCompletion 实现 completion 子命令：把 bash、zsh 或 fish 的补全脚本打印到标准输出，
+// 例如 source <(udp-traceroute completion bash)
+func runCompletion(args []string) {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: go run . completion <%s>\n", strings.Join(completionShells, "|"))
+	}
+	if flagsOnly(fs) {
+		return
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// 依次让每个子命令定义选项，收集起来
+	var cmds []cmdFlags
+	for _, c := range commands {
+		var flags []*flag.Flag
+		collectFlags = func(fs *flag.FlagSet) {
+			fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+		}
+		c.run(nil)
+		collectFlags = nil
+		sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+		cmds = append(cmds, cmdFlags{c, flags})
+	}
+
+	switch fs.Arg(0) {
+	case "bash":
+		fmt.Print(bashCompletion(cmds))
+	case "zsh":
+		fmt.Print(zshCompletion(cmds))
+	case "fish":
+		fmt.Print(fishCompletion(cmds))
+	default:
+		log.Fatalf("错误：不支持的 shell %q，可选 %s", fs.Arg(0), strings.Join(completionShells, "、"))
+	}
+}
+
+// positional 返回子命令位置参数的补全方式：主机名、文件或 shell 名
+func positional(name string) string {
+	switch name {
+	case "trace":
+		return "host"
+	case "render":
+		return "file"
+	case "completion":
+		return "shell"
+	}
+	return ""
+}
+
+func bashCompletion(cmds []cmdFlags) string {
+	var b strings.Builder
+	var names []string
+	for _, c := range cmds {
+		names = append(names, c.name)
+	}
+	fn := "_" + strings.ReplaceAll(progName, "-", "_")
+
+	fmt.Fprintf(&b, "# %s 的 bash 补全脚本，由 %s completion bash 生成\n", progName, progName)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\" cmd=trace\n")
+	fmt.Fprintf(&b, "\tcase \"${COMP_WORDS[1]}\" in\n\t%s) cmd=\"${COMP_WORDS[1]}\" ;;\n\tesac\n", strings.Join(names, "|"))
+	// 第一个位置既可以是子命令，也可以直接是 trace 的目标
+	fmt.Fprintf(&b, "\tif [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then\n")
+	fmt.Fprintf(&b, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\") $(compgen -A hostname -- \"$cur\"))\n\t\treturn\n\tfi\n", strings.Join(names, " "))
+
+	// 需要值的选项：固定取值的给出候选，文件路径按文件名补全，其余不补全
+	b.WriteString("\tcase \"$cmd:$prev\" in\n")
+	for _, c := range cmds {
+		for _, f := range c.flags {
+			if !takesValue(f) {
+				continue
+			}
+			pattern := fmt.Sprintf("%s:%s", c.name, flagName(f))
+			if len(f.Name) > 1 {
+				pattern += fmt.Sprintf("|%s:-%s", c.name, f.Name)
+			}
+			if choices := flagChoices[c.name+"."+f.Name]; choices != nil {
+				fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", pattern, strings.Join(choices, " "))
+			} else if fileFlags[f.Name] {
+				fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", pattern)
+			} else {
+				fmt.Fprintf(&b, "\t%s) COMPREPLY=(); return ;;\n", pattern)
+			}
+		}
+	}
+	b.WriteString("\tesac\n")
+
+	b.WriteString("\tif [[ $cur == -* ]]; then\n\t\tcase $cmd in\n")
+	for _, c := range cmds {
+		var opts []string
+		for _, f := range c.flags {
+			opts = append(opts, flagName(f))
+		}
+		fmt.Fprintf(&b, "\t\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", c.name, strings.Join(opts, " "))
+	}
+	b.WriteString("\t\tesac\n\t\treturn\n\tfi\n")
+
+	b.WriteString("\tcase $cmd in\n")
+	for _, c := range cmds {
+		switch positional(c.name) {
+		case "host":
+			fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -A hostname -- \"$cur\")) ;;\n", c.name)
+		case "file":
+			fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -f -- \"$cur\")) ;;\n", c.name)
+		case "shell":
+			fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", c.name, strings.Join(completionShells, " "))
+		}
+	}
+	b.WriteString("\tesac\n}\n")
+	fmt.Fprintf(&b, "complete -F %s %s\n", fn, progName)
+	return b.String()
+}
+
+// zshQuote 把说明文字转义后放进 _arguments 规格的方括号里
+func zshQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+	return strings.ReplaceAll(s, "'", `'\''`)
+}
+
+func zshCompletion(cmds []cmdFlags) string {
+	var b strings.Builder
+	fn := "_" + strings.ReplaceAll(progName, "-", "_")
+
+	fmt.Fprintf(&b, "#compdef %s\n# %s 的 zsh 补全脚本，由 %s completion zsh 生成\n\n", progName, progName, progName)
+	fmt.Fprintf(&b, "%s() {\n\tlocal cmd=trace\n\tlocal -a subcommands\n\tsubcommands=(\n", fn)
+	var names []string
+	for _, c := range cmds {
+		names = append(names, c.name)
+		fmt.Fprintf(&b, "\t\t'%s:%s'\n", c.name, zshQuote(c.summary))
+	}
+	b.WriteString("\t)\n")
+	// 第一个参数是子命令时去掉它，剩下的按该子命令的选项补全；否则按 trace 处理
+	fmt.Fprintf(&b, "\tif (( CURRENT > 2 )) && [[ ${words[2]} == (%s) ]]; then\n", strings.Join(names, "|"))
+	b.WriteString("\t\tcmd=${words[2]}\n\t\tshift words\n\t\t(( CURRENT-- ))\n")
+	b.WriteString("\telif (( CURRENT == 2 )) && [[ ${words[2]} != -* ]]; then\n")
+	b.WriteString("\t\t_describe -t commands '子命令' subcommands\n\t\t_hosts\n\t\treturn\n\tfi\n")
+
+	b.WriteString("\tcase $cmd in\n")
+	for _, c := range cmds {
+		fmt.Fprintf(&b, "\t%s)\n\t\t_arguments \\\n", c.name)
+		for _, f := range c.flags {
+			spec := fmt.Sprintf("%s[%s]", flagName(f), zshQuote(f.Usage))
+			if takesValue(f) {
+				if choices := flagChoices[c.name+"."+f.Name]; choices != nil {
+					spec += fmt.Sprintf(":%s:(%s)", f.Name, strings.Join(choices, " "))
+				} else if fileFlags[f.Name] {
+					spec += fmt.Sprintf(":%s:_files", f.Name)
+				} else {
+					spec += fmt.Sprintf(":%s: ", f.Name)
+				}
+			}
+			fmt.Fprintf(&b, "\t\t\t'%s' \\\n", spec)
+		}
+		switch positional(c.name) {
+		case "host":
+			b.WriteString("\t\t\t'1:目标地址:_hosts'\n")
+		case "file":
+			b.WriteString("\t\t\t'1:结果文件:_files'\n")
+		case "shell":
+			fmt.Fprintf(&b, "\t\t\t'1:shell:(%s)'\n", strings.Join(completionShells, " "))
+		default:
+			b.WriteString("\t\t\t&& return\n")
+		}
+		b.WriteString("\t\t;;\n")
+	}
+	b.WriteString("\tesac\n}\n\n")
+	fmt.Fprintf(&b, "compdef %s %s\n", fn, progName)
+	return b.String()
+}
+
+// fishQuote 把字符串转义成 fish 的单引号字符串
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func fishCompletion(cmds []cmdFlags) string {
+	var b strings.Builder
+	var names []string
+	for _, c := range cmds {
+		names = append(names, c.name)
+	}
+	fmt.Fprintf(&b, "# %s 的 fish 补全脚本，由 %s completion fish 生成\n", progName, progName)
+	fmt.Fprintf(&b, "complete -c %s -f\n", progName)
+	for _, c := range cmds {
+		fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n", progName, c.name, fishQuote(c.summary))
+	}
+	for _, c := range cmds {
+		// 没有写子命令时就是 trace
+		cond := fmt.Sprintf("__fish_seen_subcommand_from %s", c.name)
+		if c.name == "trace" {
+			var others []string
+			for _, n := range names {
+				if n != "trace" {
+					others = append(others, n)
+				}
+			}
+			cond = fmt.Sprintf("not __fish_seen_subcommand_from %s", strings.Join(others, " "))
+		}
+		for _, f := range c.flags {
+			fmt.Fprintf(&b, "complete -c %s -n %s", progName, fishQuote(cond))
+			if len(f.Name) == 1 {
+				fmt.Fprintf(&b, " -s %s", f.Name)
+			} else {
+				fmt.Fprintf(&b, " -l %s", f.Name)
+			}
+			if takesValue(f) {
+				if choices := flagChoices[c.name+"."+f.Name]; choices != nil {
+					fmt.Fprintf(&b, " -x -a %s", fishQuote(strings.Join(choices, " ")))
+				} else if fileFlags[f.Name] {
+					b.WriteString(" -r -F")
+				} else {
+					b.WriteString(" -x")
+				}
+			}
+			fmt.Fprintf(&b, " -d %s\n", fishQuote(f.Usage))
+		}
+		switch positional(c.name) {
+		case "host":
+			fmt.Fprintf(&b, "complete -c %s -n %s -a '(__fish_print_hostnames)'\n", progName, fishQuote(cond))
+		case "file":
+			fmt.Fprintf(&b, "complete -c %s -n %s -F\n", progName, fishQuote(cond))
+		case "shell":
+			fmt.Fprintf(&b, "complete -c %s -n %s -a %s\n", progName, fishQuote(cond), fishQuote(strings.Join(completionShells, " ")))
+		}
+	}
+	return b.String()
+}
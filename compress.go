@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 压缩格式文件开头的魔数，读取结果文件时据此判断是否需要解压，与扩展名无关
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// createOutput 创建结果文件。文件名以 .gz 结尾时写入的内容经过 gzip 压缩，
+// 长期运行的监控在存储空间有限的边缘设备上可以少占很多空间。
+func createOutput(path string) (io.WriteCloser, error) {
+	if err := checkCompression(path); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	return &gzipFile{Writer: gzip.NewWriter(f), f: f}, nil
+}
+
+// checkCompression 检查文件名要求的压缩格式是否支持
+func checkCompression(path string) error {
+	if strings.HasSuffix(path, ".zst") {
+		return fmt.Errorf("%s: 不支持 zstd 压缩，请改用 .gz", path)
+	}
+	return nil
+}
+
+// gzipFile 关闭时先写完 gzip 的尾部再关闭文件
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.f.Close()
+		return err
+	}
+	return g.f.Close()
+}
+
+// decompress 在 body 是 gzip 压缩的数据时解压，否则原样返回
+func decompress(path string, body []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(body, zstdMagic):
+		return nil, fmt.Errorf("%s 是 zstd 压缩的文件，不支持 zstd，请先用 zstd -d 解压", path)
+	case !bytes.HasPrefix(body, gzipMagic):
+		return body, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("解压 %s 失败: %w", path, err)
+	}
+	body, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("解压 %s 失败: %w", path, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ConnectTiming 记录路径探测完成之后对目标发起的完整 TCP 连接的耗时（--tcp-connect），
+// 与最后一跳的 ICMP RTT 对比，可以看出应用实际感受到的时延和网络路径本身的时延差多少
+type ConnectTiming struct {
+	Port      int       `json:"port"`              // 连接的目标端口
+	Attempts  int       `json:"attempts"`          // 尝试连接的次数
+	Succeeded int       `json:"succeeded"`         // 成功建立连接的次数
+	RTTMs     float64   `json:"rtt_ms,omitempty"`  // 成功的连接中最短的建立时间，单位毫秒
+	RTTsMs    []float64 `json:"rtts_ms,omitempty"` // 每次成功连接的建立时间
+	Error     string    `json:"error,omitempty"`   // 最后一次失败的原因
+}
+
+// measureConnect 向目标的 port 端口依次发起 n 次 TCP 连接，测量三次握手完成的时间，
+// 连接建立后立即关闭。套接字同样绑定到 --vrf 指定的设备并带上 --fwmark，
+// 和探测包走同一张路由表。
+func measureConnect(cfg probeConfig, destIP net.IP, port, n int) *ConnectTiming {
+	c := &ConnectTiming{Port: port}
+	dialer := cfg.dialer(timeout)
+	addr := net.JoinHostPort(destIP.String(), strconv.Itoa(port))
+	for i := 0; i < n; i++ {
+		c.Attempts++
+		start := time.Now()
+		conn, err := dialer.Dial("tcp4", addr)
+		if err != nil {
+			c.Error = err.Error()
+			continue
+		}
+		rtt := float64(time.Since(start)) / float64(time.Millisecond)
+		conn.Close()
+		c.Succeeded++
+		c.RTTsMs = append(c.RTTsMs, rtt)
+		if c.RTTMs == 0 || rtt < c.RTTMs {
+			c.RTTMs = rtt
+		}
+	}
+	return c
+}
+
+// reportConnect 打印 TCP 连接耗时，并和最后一个有回应的跳的 ICMP RTT 放在一起
+func reportConnect(result *TraceResult) {
+	c := result.Connect
+	if c == nil {
+		return
+	}
+	target := fmt.Sprintf("%s:%d", anon.addr(result.DestIP), c.Port)
+	if c.Succeeded == 0 {
+		fmt.Printf("TCP 连接 %s 失败（%d 次）: %s\n", target, c.Attempts, anon.text(c.Error))
+		return
+	}
+	line := fmt.Sprintf("TCP 连接 %s: %.3f ms（成功 %d/%d 次中最短）", target, c.RTTMs, c.Succeeded, c.Attempts)
+	for i := len(result.Hops) - 1; i >= 0; i-- {
+		if hop := result.Hops[i]; !hop.Timeout {
+			line += fmt.Sprintf("，最后一跳 %s 的 ICMP RTT %.3f ms", anon.addr(hop.Addr), minRTT(&hop))
+			break
+		}
+	}
+	fmt.Println(line)
+}
+
+// minRTT 返回一跳所有回应中最短的 RTT，与连接耗时取最短值的做法一致
+func minRTT(hop *Hop) float64 {
+	best := hop.RTTMs
+	for _, rtt := range hop.RTTsMs {
+		best = min(best, rtt)
+	}
+	return best
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/net/icmp"
+
+	"udp-traceroute/icmpreply"
+)
+
+// 调试输出（--debug-packets）写到标准错误，不会混进 flat 等机器可读的输出
+
+// debugSent 打印一个发出的探测包
+func debugSent(srcPort int, dest *net.UDPAddr, ttl int, seq uint16, payload []byte) {
+	fmt.Fprintf(os.Stderr, ">>> 发送 UDP :%d -> %s ttl=%d seq=%d，负载 %d 字节\n", srcPort, dest, ttl, seq, len(payload))
+	fmt.Fprint(os.Stderr, indentDump(payload))
+}
+
+// debugReceived 打印一个收到的ICMP消息以及 icmpreply.Parse 对它的解释
+func debugReceived(peer net.IP, b []byte, reply *icmpreply.Reply, err error) {
+	fmt.Fprintf(os.Stderr, "<<< 收到 ICMP，来自 %s，%d 字节\n", peer, len(b))
+	fmt.Fprint(os.Stderr, indentDump(b))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "    解析失败: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "    %s (type %d code %d)\n", icmpreply.Describe(reply.Type, reply.Code), reply.Type, reply.Code)
+	if q := reply.Quote; q != nil {
+		fmt.Fprintf(os.Stderr, "    引用: %s -> %s 协议 %d ttl %d id %d 分片偏移 %d\n", q.Src, q.Dst, q.Protocol, q.TTL, q.ID, q.FragOffset)
+		if q.HasPorts {
+			fmt.Fprintf(os.Stderr, "    引用: 端口 %d -> %d UDP长度 %d，引用了 %d 字节负载\n", q.SrcPort, q.DstPort, q.UDPLength, len(q.Payload))
+		}
+	}
+	for _, ext := range reply.Extensions {
+		fmt.Fprintf(os.Stderr, "    扩展: %s\n", describeExtension(ext))
+	}
+}
+
+// debugVerdict 打印对一个收到的消息的处理结果
+func debugVerdict(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "    => %s\n", fmt.Sprintf(format, args...))
+}
+
+// describeExtension 返回 RFC 4884 扩展对象的简要说明
+func describeExtension(ext icmp.Extension) string {
+	switch e := ext.(type) {
+	case *icmp.MPLSLabelStack:
+		var labels []string
+		for _, l := range e.Labels {
+			labels = append(labels, fmt.Sprintf("label=%d tc=%d s=%t ttl=%d", l.Label, l.TC, l.S, l.TTL))
+		}
+		return "MPLS 标签栈 [" + strings.Join(labels, "; ") + "]"
+	case *icmp.InterfaceInfo:
+		var parts []string
+		if e.Interface != nil {
+			parts = append(parts, fmt.Sprintf("ifindex=%d name=%q mtu=%d", e.Interface.Index, e.Interface.Name, e.Interface.MTU))
+		}
+		if e.Addr != nil {
+			parts = append(parts, "addr="+e.Addr.String())
+		}
+		return "接口信息 " + strings.Join(parts, " ")
+	}
+	return fmt.Sprintf("%T", ext)
+}
+
+// indentDump 返回缩进后的十六进制转储
+func indentDump(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	lines := strings.SplitAfter(hex.Dump(b), "\n")
+	return "    " + strings.Join(lines[:len(lines)-1], "    ")
+}
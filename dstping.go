@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+
+	"udp-traceroute/icmpreply"
+)
+
+// DestPing 是路径探测期间并行 ping 目标得到的统计（--dest-ping），
+// 逐跳的表格反映路径结构，它反映同一时段内端到端的丢包和时延
+type DestPing struct {
+	IntervalMs float64   `json:"interval_ms"`          // 发送间隔，单位毫秒
+	Sent       int       `json:"sent"`                 // 发出的 Echo Request 数
+	Received   int       `json:"received"`             // 在超时之前收到的 Echo Reply 数
+	Rejected   int       `json:"rejected,omitempty"`   // 被差错消息（例如 Destination Unreachable）拒绝的请求数
+	LossPct    float64   `json:"loss_pct"`             // 丢包率，0-100
+	RTTMinMs   float64   `json:"rtt_min_ms,omitempty"` // 最短 RTT，单位毫秒
+	RTTAvgMs   float64   `json:"rtt_avg_ms,omitempty"` // 平均 RTT
+	RTTMaxMs   float64   `json:"rtt_max_ms,omitempty"` // 最长 RTT
+	RTTsMs     []float64 `json:"rtts_ms,omitempty"`    // 按收到顺序排列的每个回应的 RTT
+}
+
+// destPingID 是并行 ping 使用的 ICMP Echo 标识符：取 trace 标识的高16位，
+// 和 --fallback 的 ICMP 探测（取低16位）区分开，
+// 主探测的原始套接字也据此认出这些回应是自己的，不计入被丢弃的消息
+func destPingID(traceID uint32) uint16 { return uint16(traceID >> 16) }
+
+// isDestPingReply 判断原始套接字收到的 ICMP 消息是否是并行 ping 的 Echo Reply
+func isDestPingReply(b []byte, traceID uint32) bool {
+	return len(b) >= 8 && b[0] == byte(ipv4.ICMPTypeEchoReply) && binary.BigEndian.Uint16(b[4:6]) == destPingID(traceID)
+}
+
+// quotesDestPing 判断差错消息引用的是否是并行 ping 发出的 Echo Request，
+// 例如目标或沿途的防火墙用 Destination Unreachable 拒绝了 ping
+func quotesDestPing(q *icmpreply.Quote, traceID uint32) bool {
+	return q != nil && q.Protocol == protocolICMP && len(q.Transport) >= 8 &&
+		q.Transport[0] == byte(ipv4.ICMPTypeEcho) && binary.BigEndian.Uint16(q.Transport[4:6]) == destPingID(traceID)
+}
+
+// destPinger 在路径探测进行的同时，按固定间隔向目标发送 ICMP Echo Request。
+// 它有自己的原始套接字，和逐跳探测互不等待；Echo Request 不带 TTL 限制，直接到达目标。
+type destPinger struct {
+	conn     net.PacketConn
+	dest     *net.IPAddr
+	id       uint16
+	traceID  uint32
+	interval time.Duration
+
+	mu       sync.Mutex
+	seq      uint16
+	sent     int
+	lastSent time.Time
+	pending  map[uint16]time.Time // 还没有收到回应的请求的发送时间，按序号索引
+	rtts     []float64
+	rejected int
+
+	stop chan struct{}
+	done chan struct{} // 发送循环退出时关闭
+}
+
+// newDestPinger 创建并行 ping 使用的原始套接字，和主探测的套接字一样要在放弃权限之前创建
+func newDestPinger(cfg probeConfig, destIP net.IP, interval time.Duration) (*destPinger, error) {
+	conn, err := cfg.listenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("创建并行 ping 的套接字失败: %w", err)
+	}
+	return &destPinger{
+		conn:     conn,
+		dest:     &net.IPAddr{IP: destIP},
+		id:       destPingID(cfg.TraceID),
+		traceID:  cfg.TraceID,
+		interval: interval,
+		pending:  make(map[uint16]time.Time),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// start 开始发送和接收，直到调用 finish
+func (d *destPinger) start() {
+	go d.read()
+	go d.send()
+}
+
+func (d *destPinger) send() {
+	defer close(d.done)
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		d.mu.Lock()
+		d.seq++
+		msg := icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{ID: int(d.id), Seq: int(d.seq), Data: stampProbeID(nil, d.seq, d.traceID)},
+		}
+		b, _ := msg.Marshal(nil)
+		d.lastSent = time.Now()
+		if _, err := d.conn.WriteTo(b, d.dest); err == nil {
+			d.pending[d.seq] = d.lastSent
+			d.sent++
+		}
+		d.mu.Unlock()
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// read 只接受目标发回的、标识符和序号都对得上的 Echo Reply，
+// 超过 --timeout 才到的回应按丢包计算；引用了我们的请求的差错消息单独计数
+func (d *destPinger) read() {
+	buf := make([]byte, maxPacketLen)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		at := time.Now()
+		reply, err := icmpreply.Parse(buf[:n])
+		if err != nil {
+			continue
+		}
+		var seq uint16
+		switch {
+		case isDestPingReply(buf[:n], d.traceID) && addr.(*net.IPAddr).IP.Equal(d.dest.IP):
+			seq = binary.BigEndian.Uint16(buf[6:8])
+		case quotesDestPing(reply.Quote, d.traceID) && reply.Quote.Dst.Equal(d.dest.IP):
+			seq = binary.BigEndian.Uint16(reply.Quote.Transport[6:8])
+		default:
+			continue
+		}
+		d.mu.Lock()
+		if sentAt, ok := d.pending[seq]; ok {
+			delete(d.pending, seq)
+			if reply.Quote != nil {
+				d.rejected++
+			} else if rtt := at.Sub(sentAt); rtt <= timeout {
+				d.rtts = append(d.rtts, float64(rtt)/float64(time.Millisecond))
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// finish 停止发送，再等最后一个请求的回应最多 --timeout，然后关闭套接字并汇总统计
+func (d *destPinger) finish() *DestPing {
+	close(d.stop)
+	<-d.done
+	d.mu.Lock()
+	wait := time.Until(d.lastSent.Add(timeout))
+	d.mu.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	d.conn.Close()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := &DestPing{
+		IntervalMs: float64(d.interval) / float64(time.Millisecond),
+		Sent:       d.sent,
+		Received:   len(d.rtts),
+		Rejected:   d.rejected,
+		RTTsMs:     d.rtts,
+	}
+	if s.Sent > 0 {
+		s.LossPct = float64(s.Sent-s.Received) / float64(s.Sent) * 100
+	}
+	if len(d.rtts) > 0 {
+		s.RTTMinMs, s.RTTMaxMs = d.rtts[0], d.rtts[0]
+		var sum float64
+		for _, rtt := range d.rtts {
+			s.RTTMinMs = min(s.RTTMinMs, rtt)
+			s.RTTMaxMs = max(s.RTTMaxMs, rtt)
+			sum += rtt
+		}
+		s.RTTAvgMs = sum / float64(len(d.rtts))
+	}
+	return s
+}
+
+// reportDestPing 在逐跳的表格之后打印同一时段内目标本身的丢包和时延
+func reportDestPing(result *TraceResult) {
+	s := result.DestPing
+	if s == nil {
+		return
+	}
+	line := fmt.Sprintf("探测期间并行 ping %s: 发送 %d，收到 %d，丢包 %.1f%%", anon.addr(result.DestIP), s.Sent, s.Received, s.LossPct)
+	if s.Received > 0 {
+		line += fmt.Sprintf("，RTT 最短/平均/最长 %.3f/%.3f/%.3f ms", s.RTTMinMs, s.RTTAvgMs, s.RTTMaxMs)
+	}
+	if s.Rejected > 0 {
+		line += fmt.Sprintf("（%d 个请求被 ICMP 差错消息拒绝）", s.Rejected)
+	}
+	fmt.Println(line)
+}
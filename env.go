@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// envPrefix 是所有环境变量配置项的前缀，
+// 例如 --webhook-secret 对应 TRACEROUTE_WEBHOOK_SECRET。
+const envPrefix = "TRACEROUTE_"
+
+// envName 把选项名转换成对应的环境变量名
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// flagAliases 把 traceroute 风格的单字母选项映射到它们对应的长选项，
+// 两者设置的是同一个值，命令行中出现任何一个都算这个选项被设置过
+var flagAliases = map[string]string{
+	"q": "probes",
+	"w": "wait",
+	"m": "max-hops",
+	"f": "first-ttl",
+	"p": "port",
+	"n": "numeric",
+	"I": "icmp",
+	"T": "tcp",
+	"U": "udp",
+}
+
+// applyEnv 用 TRACEROUTE_* 环境变量填充命令行中没有显式设置的选项。
+// 优先级为：命令行 > 环境变量 > 默认值，这样容器和 systemd 单元
+// 不需要包装脚本就能完成配置，而临时在命令行覆盖某个值依然有效。
+func applyEnv(fs *flag.FlagSet) error {
+	// 先记录下命令行中已经出现过的选项
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+		if long, ok := flagAliases[f.Name]; ok {
+			set[long] = true
+		}
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		// -n、-q 这类单字母选项只是 traceroute 风格的别名，环境变量使用它们对应的长选项
+		if err != nil || set[f.Name] || len(f.Name) == 1 {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if e := fs.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("环境变量 %s 的值 %q 无效: %w", envName(f.Name), value, e)
+		}
+	})
+	return err
+}
+
+// flagSet 报告某个选项是否在命令行或环境变量中被显式设置过，
+// 通过单字母别名设置的也算
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name || flagAliases[f.Name] == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// aliasEnvNames 列出 fs 中每个单字母别名对应的环境变量，按字母排序
+func aliasEnvNames(fs *flag.FlagSet) string {
+	var parts []string
+	for _, short := range slices.Sorted(maps.Keys(flagAliases)) {
+		if fs.Lookup(short) != nil {
+			parts = append(parts, fmt.Sprintf("-%s 对应 %s", short, envName(flagAliases[short])))
+		}
+	}
+	return strings.Join(parts, "、")
+}
+
+// envUsage 在帮助信息的末尾补充环境变量的说明
+func envUsage(fs *flag.FlagSet) {
+	example := "webhook"
+	if fs.Lookup(example) == nil {
+		// 其他子命令没有 --webhook，用它的第一个长选项举例
+		fs.VisitAll(func(f *flag.Flag) {
+			if example == "webhook" && len(f.Name) > 1 {
+				example = f.Name
+			}
+		})
+	}
+	fmt.Fprintf(fs.Output(), "\n所有选项都可以通过环境变量设置，例如 --%s 对应 %s，\n", example, envName(example))
+	if names := aliasEnvNames(fs); names != "" {
+		fmt.Fprintf(fs.Output(), "traceroute 兼容的单字母选项使用对应长选项的变量：%s。\n", names)
+	}
+	if fs.Name() == "trace" {
+		fmt.Fprintf(fs.Output(), "目标地址可以通过 %sTARGET 设置。", envPrefix)
+	}
+	fmt.Fprintf(fs.Output(), "命令行参数的优先级高于环境变量。\n")
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+
+	"udp-traceroute/icmpreply"
+)
+
+// 探测使用的协议，使用 --fallback 时记在每一跳的 protocol 字段中
+const (
+	protoUDP  = "udp"
+	protoICMP = "icmp"
+	protoTCP  = "tcp"
+)
+
+// IP头中ICMP和TCP的协议号
+const (
+	protocolICMP = 1
+	protocolTCP  = 6
+)
+
+const (
+	fallbackStall   = 3   // 连续多少跳没有回应时认为当前协议的探测停滞了
+	fallbackTCPPort = 443 // --fallback 的 TCP SYN 探测的目标端口，防火墙最常放行的端口之一
+	tcpDefaultPort  = 80  // -T 没有指定 -p 时的目标端口，和 traceroute -T 相同
+)
+
+// TCP头中用到的标志位
+const (
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+)
+
+// fallback 是 UDP 探测停滞之后改用的一种探测方式（--fallback）
+type fallback struct {
+	proto string
+	p     prober
+}
+
+// openTCPProber 创建 TCP 探测模式（-T 或 URL 形式的目标）使用的探测器，SYN 发往 port
+func openTCPProber(cfg probeConfig, port int) (prober, error) {
+	p, err := newAltProber(cfg, protoTCP, port)
+	if errors.Is(err, os.ErrPermission) {
+		return nil, fmt.Errorf("TCP SYN 探测需要原始套接字权限。%s", permissionRemedy())
+	}
+	return p, err
+}
+
+// openFallbacks 按顺序创建 --fallback 使用的 ICMP Echo 和 TCP SYN 探测器。
+// 它们都需要原始套接字，必须在放弃 root 权限之前创建。
+func openFallbacks(cfg probeConfig) ([]fallback, error) {
+	var fallbacks []fallback
+	for _, proto := range []string{protoICMP, protoTCP} {
+		p, err := newAltProber(cfg, proto, fallbackTCPPort)
+		if err != nil {
+			for _, f := range fallbacks {
+				f.p.Close()
+			}
+			return nil, err
+		}
+		fallbacks = append(fallbacks, fallback{proto: proto, p: p})
+	}
+	return fallbacks, nil
+}
+
+// altProber 用 ICMP Echo 或 TCP SYN 代替UDP探测，很多防火墙会丢弃发往高位端口的UDP包，
+// 却放行 ping 和 HTTPS。报文由 rawSender 构造，序号写在 IP ID 中，
+// Time Exceeded 引用的IP头里一定带着它；ICMP Echo 的标识符和 TCP 的序列号里还带着 trace 标识。
+// 目标本身的回应是 Echo Reply，或者 TCP 的 SYN-ACK、RST。
+type altProber struct {
+	proto   string
+	sender  *rawSender
+	conns   []net.PacketConn    // ICMP监听套接字，TCP模式下还有一个接收TCP报文的原始套接字
+	packets chan capturedPacket // 各监听套接字读到的报文
+	srcPort int                 // TCP SYN 的源端口
+	dstPort int                 // TCP SYN 的目标端口
+	traceID uint32
+	seq     uint16 // 最近一个探测包的序号
+}
+
+// capturedPacket 是从某个监听套接字读到的、去掉了IP头的报文
+type capturedPacket struct {
+	protocol int
+	data     []byte
+	peer     net.IP
+	at       time.Time
+}
+
+func newAltProber(cfg probeConfig, proto string, tcpPort int) (*altProber, error) {
+	a := &altProber{
+		proto:   proto,
+		packets: make(chan capturedPacket, 64),
+		// 没有真正的TCP连接使用这个端口，目标回应的 SYN-ACK 会被内核用 RST 回绝
+		srcPort: 32768 + rand.IntN(28232),
+		dstPort: tcpPort,
+		traceID: cfg.TraceID,
+	}
+	networks := map[int]string{protocolICMP: "ip4:icmp"}
+	if proto == protoTCP {
+		networks[protocolTCP] = "ip4:tcp"
+	}
+	for protocol, network := range networks {
+		c, err := cfg.listenPacket(network, "0.0.0.0")
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("创建 %s 监听套接字失败: %w", network, err)
+		}
+		a.conns = append(a.conns, c)
+		go a.read(c, protocol)
+	}
+	sender, err := newRawSender(cfg, a.srcPort)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	a.sender = sender
+	return a, nil
+}
+
+// read 不断读取一个监听套接字，直到它被关闭。
+// 来不及处理的报文直接丢弃，它们不可能属于正在等待的探测包。
+func (a *altProber) read(c net.PacketConn, protocol int) {
+	buf := make([]byte, maxPacketLen)
+	for {
+		n, addr, err := c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pkt := capturedPacket{protocol: protocol, data: append([]byte(nil), buf[:n]...), peer: addr.(*net.IPAddr).IP, at: time.Now()}
+		select {
+		case a.packets <- pkt:
+		default:
+		}
+	}
+}
+
+func (a *altProber) mode() string {
+	if a.proto == protoTCP {
+		return fmt.Sprintf("TCP SYN（端口 %d）", a.dstPort)
+	}
+	return "ICMP Echo"
+}
+
+func (a *altProber) drainEvents() []ReplyEvent { return nil }
+func (a *altProber) rejected() map[string]int  { return nil }
+func (a *altProber) lastSeq() uint16           { return a.seq }
+
+func (a *altProber) Close() error {
+	for _, c := range a.conns {
+		c.Close()
+	}
+	if a.sender != nil {
+		a.sender.Close()
+	}
+	return nil
+}
+
+// probe 向 dest.IP 发送一个 ICMP Echo 或 TCP SYN 探测包，dest.Port 和 payload 不使用
+func (a *altProber) probe(ttl int, dest *net.UDPAddr, payload []byte) (*probeReply, error) {
+	a.seq++
+	if a.seq == 0 {
+		a.seq = 1
+	}
+	src, err := a.sender.source(dest.IP)
+	if err != nil {
+		return nil, err
+	}
+	protocol, l4 := protocolICMP, a.echo()
+	if a.proto == protoTCP {
+		protocol, l4 = protocolTCP, a.syn(src, dest.IP)
+	}
+	sentAt := time.Now()
+	if err := a.sender.sendIP(ttl, src, dest.IP, a.seq, protocol, l4); err != nil {
+		return nil, fmt.Errorf("发送 %s 探测包失败: %w", a.mode(), err)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-deadline.C:
+			return nil, nil
+		case pkt := <-a.packets:
+			reply := a.match(pkt, dest.IP)
+			if reply == nil {
+				continue
+			}
+			reply.RTT = pkt.at.Sub(sentAt)
+			reply.SentAt, reply.ReceivedAt = sentAt, pkt.at
+			return reply, nil
+		}
+	}
+}
+
+// echo 构造 ICMP Echo 请求，标识符是 trace 标识的低16位
+func (a *altProber) echo() []byte {
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: int(uint16(a.traceID)), Seq: int(a.seq), Data: stampProbeID(nil, a.seq, a.traceID)},
+	}
+	b, _ := msg.Marshal(nil)
+	return b
+}
+
+// tcpSeq 是 SYN 的序列号：高16位是探测序号，低16位是 trace 标识的低16位
+func (a *altProber) tcpSeq() uint32 { return uint32(a.seq)<<16 | a.traceID&0xffff }
+
+// syn 构造不带选项的 TCP SYN 报文
+func (a *altProber) syn(src, dst net.IP) []byte {
+	seg := make([]byte, 20)
+	binary.BigEndian.PutUint16(seg[0:2], uint16(a.srcPort))
+	binary.BigEndian.PutUint16(seg[2:4], uint16(a.dstPort))
+	binary.BigEndian.PutUint32(seg[4:8], a.tcpSeq())
+	seg[12] = 5 << 4 // 数据偏移：5个32位字
+	seg[13] = tcpFlagSYN
+	binary.BigEndian.PutUint16(seg[14:16], 1024) // 窗口
+	binary.BigEndian.PutUint16(seg[16:18], pseudoChecksum(src, dst, protocolTCP, seg))
+	return seg
+}
+
+// match 检查报文是否是当前探测包的回应，是的话返回回应的内容（不含时间）
+func (a *altProber) match(pkt capturedPacket, dest net.IP) *probeReply {
+	if pkt.protocol == protocolTCP {
+		// 目标对 SYN 的回应：SYN-ACK 表示端口开放，RST 表示端口关闭，两者的确认号都是我们的序列号加 1
+		seg := pkt.data
+		if len(seg) < 20 || !pkt.peer.Equal(dest) ||
+			int(binary.BigEndian.Uint16(seg[0:2])) != a.dstPort ||
+			int(binary.BigEndian.Uint16(seg[2:4])) != a.srcPort ||
+			binary.BigEndian.Uint32(seg[8:12]) != a.tcpSeq()+1 {
+			return nil
+		}
+		if flags := seg[13]; flags&(tcpFlagSYN|tcpFlagACK) != tcpFlagSYN|tcpFlagACK && flags&tcpFlagRST == 0 {
+			return nil
+		}
+		return &probeReply{Peer: pkt.peer, FromTarget: true}
+	}
+
+	reply, err := icmpreply.Parse(pkt.data)
+	if err != nil {
+		return nil
+	}
+	if reply.Type == ipv4.ICMPTypeEchoReply {
+		if a.proto != protoICMP || !pkt.peer.Equal(dest) ||
+			binary.BigEndian.Uint16(pkt.data[4:6]) != uint16(a.traceID) ||
+			binary.BigEndian.Uint16(pkt.data[6:8]) != a.seq {
+			return nil
+		}
+		return &probeReply{Peer: pkt.peer, Type: reply.Type, FromTarget: true}
+	}
+
+	// 差错消息必须引用当前探测包：目标地址、IP ID 都对得上，
+	// 引用中带有传输层头部时，Echo 的标识符或 TCP 的端口也要对得上
+	q := reply.Quote
+	if q == nil || !q.Dst.Equal(dest) || uint16(q.ID) != a.seq {
+		return nil
+	}
+	switch a.proto {
+	case protoICMP:
+		if q.Protocol != protocolICMP || (len(q.Transport) >= 6 && binary.BigEndian.Uint16(q.Transport[4:6]) != uint16(a.traceID)) {
+			return nil
+		}
+	case protoTCP:
+		if q.Protocol != protocolTCP || (q.HasPorts && (q.SrcPort != a.srcPort || q.DstPort != a.dstPort)) {
+			return nil
+		}
+	}
+	return &probeReply{Peer: pkt.peer, Type: reply.Type, Code: reply.Code}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// hopFilter 是 --filter 编译好的表达式，决定哪些跳会被输出。
+// 表达式由比较组成，可以用 &&、||、! 和括号组合，例如
+//
+//	rtt > 100ms || loss > 0
+//	state == timeout && ttl > 3
+//	!(class == private) && asn != 0
+//
+// 可以使用的字段见 filterFields。rtt 和 avg 以毫秒比较，值可以带 ms 或 s 单位；
+// loss 是百分比，值可以带 %；字符串值可以加引号，也可以直接写。
+// 布尔字段可以单独出现，例如 rate_limited || as_boundary。
+type hopFilter struct {
+	match func(h *Hop) bool
+}
+
+// 字段的类型
+const (
+	fieldNumber = iota
+	fieldString
+	fieldBool
+)
+
+// filterField 描述一个可以在表达式中使用的字段
+type filterField struct {
+	kind  int
+	value func(h *Hop) any
+}
+
+// filterFields 列出表达式中可以使用的字段
+var filterFields = map[string]filterField{
+	"ttl":          {fieldNumber, func(h *Hop) any { return float64(h.TTL) }},
+	"rtt":          {fieldNumber, func(h *Hop) any { return h.RTTMs }},
+	"avg":          {fieldNumber, func(h *Hop) any { return h.RTTAvgMs }},
+	"loss":         {fieldNumber, func(h *Hop) any { return h.LossPct }},
+	"asn":          {fieldNumber, func(h *Hop) any { return float64(h.ASN) }},
+	"mtu":          {fieldNumber, func(h *Hop) any { return float64(h.MTU) }},
+	"addr":         {fieldString, func(h *Hop) any { return h.Addr }},
+	"state":        {fieldString, func(h *Hop) any { return h.State }},
+	"class":        {fieldString, func(h *Hop) any { return h.Class }},
+	"rpki":         {fieldString, func(h *Hop) any { return h.RPKI }},
+	"ixp":          {fieldString, func(h *Hop) any { return h.IXP }},
+	"label":        {fieldString, func(h *Hop) any { return ipamField(h, func(i *IPAMInfo) string { return i.Label }) }},
+	"site":         {fieldString, func(h *Hop) any { return ipamField(h, func(i *IPAMInfo) string { return i.Site }) }},
+	"protocol":     {fieldString, func(h *Hop) any { return h.Protocol }},
+	"timeout":      {fieldBool, func(h *Hop) any { return h.Timeout }},
+	"rate_limited": {fieldBool, func(h *Hop) any { return h.RateLimited }},
+	"as_boundary":  {fieldBool, func(h *Hop) any { return h.ASBoundary }},
+	"ptr_mismatch": {fieldBool, func(h *Hop) any { return h.PTRMismatch }},
+}
+
+// ipamField 取出一跳的 IPAM 描述中的某一项，没有描述时为空
+func ipamField(h *Hop, get func(*IPAMInfo) string) string {
+	if h.IPAM == nil {
+		return ""
+	}
+	return get(h.IPAM)
+}
+
+// parseFilter 编译一个过滤表达式
+func parseFilter(expr string) (*hopFilter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	match, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("过滤表达式在 %q 处有多余的内容", p.tokens[p.pos])
+	}
+	return &hopFilter{match: match}, nil
+}
+
+// allows 报告一跳是否应该输出，f 为 nil 时所有跳都输出
+func (f *hopFilter) allows(h *Hop) bool {
+	return f == nil || f.match(h)
+}
+
+// result 返回只保留满足表达式的跳的结果副本，f 为 nil 时原样返回
+func (f *hopFilter) result(r *TraceResult) *TraceResult {
+	if f == nil {
+		return r
+	}
+	filtered := *r
+	filtered.Hops = make([]Hop, 0, len(r.Hops))
+	for i := range r.Hops {
+		if f.match(&r.Hops[i]) {
+			filtered.Hops = append(filtered.Hops, r.Hops[i])
+		}
+	}
+	return &filtered
+}
+
+// tokenizeFilter 把表达式切分成运算符、括号、引号字符串和单词
+func tokenizeFilter(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"),
+			strings.HasPrefix(s[i:], "=="), strings.HasPrefix(s[i:], "!="),
+			strings.HasPrefix(s[i:], "<="), strings.HasPrefix(s[i:], ">="):
+			tokens = append(tokens, s[i:i+2])
+			i += 2
+		case strings.ContainsRune("()<>!", rune(c)):
+			tokens = append(tokens, s[i:i+1])
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("过滤表达式中的引号没有闭合")
+			}
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t()<>!=&|\"'", rune(s[j])) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("过滤表达式中有无法识别的字符 %q", c)
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// filterParser 是过滤表达式的递归下降解析器，
+// 优先级从低到高依次是 ||、&&、!、比较
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *filterParser) or() (func(*Hop) bool, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(h *Hop) bool { return l(h) || right(h) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (func(*Hop) bool, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(h *Hop) bool { return l(h) && right(h) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) unary() (func(*Hop) bool, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(h *Hop) bool { return !inner(h) }, nil
+	case "(":
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("过滤表达式缺少右括号")
+		}
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+// comparison 解析“字段 运算符 值”，或者单独出现的布尔字段
+func (p *filterParser) comparison() (func(*Hop) bool, error) {
+	name := p.next()
+	field, ok := filterFields[name]
+	if !ok {
+		return nil, fmt.Errorf("过滤表达式中有未知的字段 %q", name)
+	}
+	op := p.peek()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+	default:
+		if field.kind != fieldBool {
+			return nil, fmt.Errorf("字段 %s 后面缺少比较运算符", name)
+		}
+		return func(h *Hop) bool { return field.value(h).(bool) }, nil
+	}
+	raw := p.next()
+	if raw == "" {
+		return nil, fmt.Errorf("字段 %s 的比较缺少值", name)
+	}
+
+	switch field.kind {
+	case fieldNumber:
+		want, err := parseFilterNumber(name, raw)
+		if err != nil {
+			return nil, err
+		}
+		return func(h *Hop) bool { return compareNumber(field.value(h).(float64), op, want) }, nil
+	case fieldBool:
+		want, err := strconv.ParseBool(raw)
+		if err != nil || (op != "==" && op != "!=") {
+			return nil, fmt.Errorf("布尔字段 %s 只能和 true 或 false 做 == 或 != 比较", name)
+		}
+		return func(h *Hop) bool { return (field.value(h).(bool) == want) == (op == "==") }, nil
+	}
+	if op != "==" && op != "!=" {
+		return nil, fmt.Errorf("字符串字段 %s 只能做 == 或 != 比较", name)
+	}
+	want := strings.Trim(raw, `"'`)
+	return func(h *Hop) bool { return (field.value(h).(string) == want) == (op == "==") }, nil
+}
+
+// parseFilterNumber 解析数值，rtt 和 avg 允许 ms 和 s 单位，loss 允许 % 后缀
+func parseFilterNumber(name, raw string) (float64, error) {
+	scale := 1.0
+	switch {
+	case (name == "rtt" || name == "avg") && strings.HasSuffix(raw, "ms"):
+		raw = strings.TrimSuffix(raw, "ms")
+	case (name == "rtt" || name == "avg") && strings.HasSuffix(raw, "s"):
+		raw, scale = strings.TrimSuffix(raw, "s"), 1000
+	case name == "loss":
+		raw = strings.TrimSuffix(raw, "%")
+	}
+	if raw == "" || !unicode.IsDigit(rune(raw[len(raw)-1])) {
+		return 0, fmt.Errorf("字段 %s 的值 %q 不是有效的数字", name, raw)
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("字段 %s 的值 %q 不是有效的数字", name, raw)
+	}
+	return v * scale, nil
+}
+
+func compareNumber(got float64, op string, want float64) bool {
+	switch op {
+	case "==":
+		return got == want
+	case "!=":
+		return got != want
+	case "<":
+		return got < want
+	case "<=":
+		return got <= want
+	case ">":
+		return got > want
+	}
+	return got >= want
+}
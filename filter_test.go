@@ -0,0 +1,137 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// filterHops 是过滤测试共用的一条路径
+var filterHops = []Hop{
+	{TTL: 1, Addr: "192.168.1.1", Class: "private", State: hopResponded, RTTMs: 0.5},
+	{TTL: 2, Timeout: true, State: hopTimeout, LossPct: 100},
+	{TTL: 3, Addr: "203.0.113.9", State: hopRateLimited, RTTMs: 12, LossPct: 50, RateLimited: true, ASN: 64500},
+	{TTL: 4, Addr: "198.51.100.1", State: hopResponded, RTTMs: 150, ASN: 64501, ASBoundary: true},
+	{TTL: 5, Addr: "8.8.8.8", State: hopReached, RTTMs: 1500, ASN: 15169, IPAM: &IPAMInfo{Label: "edge", Site: "hkg"}},
+}
+
+// matchedTTLs 返回 filterHops 中满足表达式的跳的 TTL
+func matchedTTLs(t *testing.T, expr string) []int {
+	t.Helper()
+	f := mustFilter(t, expr)
+	var ttls []int
+	for i := range filterHops {
+		if f.allows(&filterHops[i]) {
+			ttls = append(ttls, filterHops[i].TTL)
+		}
+	}
+	return ttls
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []int
+	}{
+		{"ttl > 3", []int{4, 5}},
+		{"ttl>=3&&ttl<=4", []int{3, 4}},
+		{"rtt > 100ms", []int{4, 5}},
+		{"rtt > 1s", []int{5}},
+		{"avg == 0", []int{1, 2, 3, 4, 5}},
+		{"loss > 0", []int{2, 3}},
+		{"loss >= 50%", []int{2, 3}},
+		{"state == timeout", []int{2}},
+		{`state == "rate-limited"`, []int{3}},
+		{"class != private", []int{2, 3, 4, 5}},
+		{"label == 'edge' && site == hkg", []int{5}},
+		{"rate_limited", []int{3}},
+		{"as_boundary == true", []int{4}},
+		{"timeout != false", []int{2}},
+		{"!timeout", []int{1, 3, 4, 5}},
+		{"!(asn == 0)", []int{3, 4, 5}},
+		{"!!timeout", []int{2}},
+		// && 的优先级高于 ||
+		{"ttl == 1 || ttl == 4 && asn == 64501", []int{1, 4}},
+		{"ttl == 4 && asn == 64501 || ttl == 1", []int{1, 4}},
+		{"(ttl == 1 || ttl == 4) && asn == 64501", []int{4}},
+		// ! 只作用于紧跟它的一项
+		{"!timeout && ttl < 3", []int{1}},
+		{"!(timeout || ttl < 3)", []int{3, 4, 5}},
+	}
+	for _, tt := range tests {
+		if got := matchedTTLs(t, tt.expr); !slices.Equal(got, tt.want) {
+			t.Errorf("%q 匹配 %v，期望 %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestFilterParseErrors(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{"", "未知的字段"},
+		{"speed > 1", "未知的字段"},
+		{"ttl", "缺少比较运算符"},
+		{"ttl >", "缺少值"},
+		{"ttl > abc", "不是有效的数字"},
+		{"rtt > 10x", "不是有效的数字"},
+		{"loss > %", "不是有效的数字"},
+		{"state > timeout", "只能做 == 或 != 比较"},
+		{"timeout < true", "只能和 true 或 false"},
+		{"timeout == maybe", "只能和 true 或 false"},
+		{"(ttl > 1", "缺少右括号"},
+		{"ttl > 1)", "多余的内容"},
+		{"ttl > 1 ttl", "多余的内容"},
+		{`state == "timeout`, "引号没有闭合"},
+		{"ttl > 1 & ttl < 3", "无法识别的字符"},
+		{"ttl = 1", "无法识别的字符"},
+	}
+	for _, tt := range tests {
+		_, err := parseFilter(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseFilter(%q) 返回 %v，期望包含 %q 的错误", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestFilterResult(t *testing.T) {
+	result := &TraceResult{Target: "8.8.8.8", Reached: true, Hops: filterHops}
+
+	// 没有过滤表达式时原样返回
+	var none *hopFilter
+	if got := none.result(result); got != result {
+		t.Errorf("nil 过滤器返回了副本")
+	}
+	if !none.allows(&filterHops[1]) {
+		t.Errorf("nil 过滤器丢弃了一跳")
+	}
+
+	got := mustFilter(t, "asn != 0 && !rate_limited").result(result)
+	var ttls []int
+	for _, h := range got.Hops {
+		ttls = append(ttls, h.TTL)
+	}
+	if !slices.Equal(ttls, []int{4, 5}) {
+		t.Errorf("过滤后的跳 %v，期望 [4 5]", ttls)
+	}
+	if got.Target != result.Target || !got.Reached {
+		t.Errorf("过滤改变了跳以外的字段: %+v", got)
+	}
+	if len(result.Hops) != len(filterHops) {
+		t.Errorf("过滤修改了原结果，剩下 %d 跳", len(result.Hops))
+	}
+
+	// 没有跳满足条件时是空数组而不是 nil，JSON 中仍然有 "hops": []
+	if empty := mustFilter(t, "ttl > 100").result(result); empty.Hops == nil || len(empty.Hops) != 0 {
+		t.Errorf("没有跳满足条件时得到 %#v", empty.Hops)
+	}
+}
+
+func mustFilter(t *testing.T, expr string) *hopFilter {
+	t.Helper()
+	f, err := parseFilter(expr)
+	if err != nil {
+		t.Fatalf("parseFilter(%q): %v", expr, err)
+	}
+	return f
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+)
+
+// 逐跳输出的格式（--output）
+const (
+	outputTable = "table" // 对齐的表格，列由 --columns 决定
+	outputFlat  = "flat"  // 每跳一行 key=value，便于 grep/awk 和日志系统处理
+)
+
+// formatFlat 把一跳格式化成一行 key=value。
+// 每一行都带上目标，这样多个 trace 的输出混在一起时仍然可以区分。
+func formatFlat(target string, hop *Hop) string {
+	fields := []string{kv("target", anon.addr(target)), kv("ttl", strconv.Itoa(hop.TTL))}
+	if hop.Timeout {
+		fields = append(fields, kv("status", "timeout"))
+	} else {
+		fields = append(fields,
+			kv("ip", anon.addr(hop.Addr)),
+			kv("rtt_ms", strconv.FormatFloat(hop.RTTMs, 'f', 3, 64)),
+			kv("status", flatStatus(hop.ICMPType)))
+	}
+	if hop.State != "" {
+		// status 只反映ICMP类型，state 才区分到达目标的端口不可达和中间路由器的 !H、!N
+		fields = append(fields, kv("state", hop.State))
+	}
+	if hop.Protocol != "" {
+		fields = append(fields, kv("protocol", hop.Protocol))
+	}
+	if len(hop.Ports) > 0 {
+		fields = append(fields, kv("ports", joinPorts(hop.Ports)))
+	}
+	if hop.Class != "" {
+		fields = append(fields, kv("addr_class", hop.Class))
+	}
+	if hop.ASN != 0 {
+		fields = append(fields, kv("asn", strconv.Itoa(anon.asn(hop.ASN))))
+		if hop.ASBoundary {
+			fields = append(fields, kv("as_boundary", "true"))
+		}
+		if hop.RPKI != "" {
+			fields = append(fields, kv("prefix", anon.prefix(hop.Prefix)), kv("rpki", hop.RPKI))
+		}
+	}
+	if hop.IXP != "" {
+		fields = append(fields, kv("ixp", hop.IXP))
+	}
+	if g := hop.Geo; g != nil {
+		fields = append(fields,
+			kv("geo_lat", strconv.FormatFloat(anon.coord(g.Lat), 'f', -1, 64)),
+			kv("geo_lon", strconv.FormatFloat(anon.coord(g.Lon), 'f', -1, 64)))
+		if g.Label != "" {
+			fields = append(fields, kv("geo_label", anon.host(g.Label)))
+		}
+		if g.Impossible {
+			fields = append(fields, kv("geo_impossible", "true"))
+		}
+	}
+	if hop.IPAM != nil {
+		fields = append(fields, kv("ipam_label", anon.host(hop.IPAM.Label)))
+		if hop.IPAM.Site != "" {
+			fields = append(fields, kv("ipam_site", anon.host(hop.IPAM.Site)))
+		}
+		if hop.IPAM.Owner != "" {
+			fields = append(fields, kv("ipam_owner", anon.host(hop.IPAM.Owner)))
+		}
+	}
+	if hop.Host != "" {
+		fields = append(fields, kv("host", anon.host(hop.Host)))
+	}
+	if hop.PTRMismatch {
+		fields = append(fields, kv("ptr_mismatch", "true"))
+	}
+	if hop.NextMTU > 0 {
+		fields = append(fields, kv("next_hop_mtu", strconv.Itoa(hop.NextMTU)))
+	}
+	if hop.ReplyTTL > 0 {
+		fields = append(fields, kv("reply_ttl", strconv.Itoa(hop.ReplyTTL)))
+	}
+	if hop.QuotedTTL > 0 {
+		fields = append(fields, kv("quoted_ttl", strconv.Itoa(hop.QuotedTTL)))
+	}
+	if hop.Tunnel != "" {
+		fields = append(fields, kv("tunnel", hop.Tunnel))
+	}
+	if hop.HiddenHops > 0 {
+		fields = append(fields, kv("hidden_hops", strconv.Itoa(hop.HiddenHops)))
+	}
+	if hop.Sent > 1 {
+		fields = append(fields,
+			kv("sent", strconv.Itoa(hop.Sent)),
+			kv("received", strconv.Itoa(hop.Received)),
+			kv("loss_pct", strconv.FormatFloat(hop.LossPct, 'f', 1, 64)))
+		if hop.Received > 0 {
+			fields = append(fields, kv("rtt_avg_ms", strconv.FormatFloat(hop.RTTAvgMs, 'f', 3, 64)))
+		}
+	}
+	fields = append(fields, flatMeasurements(hop)...)
+	return strings.Join(fields, " ")
+}
+
+// flatMeasurements 返回路径探测完成之后才得出的字段：ICMP限速判定、MTU 和链路估计
+func flatMeasurements(hop *Hop) []string {
+	var fields []string
+	if hop.RateLimited {
+		fields = append(fields, kv("rate_limited", "true"))
+	}
+	if hop.MTU > 0 {
+		fields = append(fields, kv("mtu", strconv.Itoa(hop.MTU)))
+	}
+	if l := hop.Link; l != nil {
+		fields = append(fields,
+			kv("link_bw_bps", strconv.FormatFloat(l.BandwidthBps, 'f', 0, 64)),
+			kv("link_bw_low_bps", strconv.FormatFloat(l.BandwidthLowBps, 'f', 0, 64)),
+			kv("link_latency_ms", strconv.FormatFloat(l.LatencyMs, 'f', 3, 64)))
+		if l.BandwidthHighBps > 0 {
+			fields = append(fields, kv("link_bw_high_bps", strconv.FormatFloat(l.BandwidthHighBps, 'f', 0, 64)))
+		}
+	}
+	return fields
+}
+
+// formatFlatMeasured 在探测时逐跳输出的行已经写出之后，把 flatMeasurements 补成单独的一行，
+// 以 record=measured 和逐跳的行区分；这一跳没有这些测量值时返回空字符串
+func formatFlatMeasured(target string, hop *Hop) string {
+	fields := flatMeasurements(hop)
+	if len(fields) == 0 {
+		return ""
+	}
+	head := []string{kv("target", anon.addr(target)), kv("ttl", strconv.Itoa(hop.TTL)), kv("record", "measured")}
+	return strings.Join(append(head, fields...), " ")
+}
+
+// writeFlatMeasured 对满足 filter 的每一跳写出 formatFlatMeasured 的行
+func writeFlatMeasured(w io.Writer, result *TraceResult, filter *hopFilter) {
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		if !filter.allows(hop) {
+			continue
+		}
+		if line := formatFlatMeasured(result.Target, hop); line != "" {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// flatStatus 返回ICMP类型在 flat 输出中的名字
+func flatStatus(t int) string {
+	switch ipv4.ICMPType(t) {
+	case ipv4.ICMPTypeTimeExceeded:
+		return "time_exceeded"
+	case ipv4.ICMPTypeDestinationUnreachable:
+		return "unreachable"
+	case ipv4.ICMPTypeEchoReply:
+		// 也包括 --fallback 的 TCP 回应，它没有ICMP类型，协议见 protocol
+		return "reply"
+	}
+	return fmt.Sprintf("icmp_%d", t)
+}
+
+// kv 生成一个 key=value，值中含有空白、引号或等号时加上引号
+func kv(key, value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\"=") {
+		value = strconv.Quote(value)
+	}
+	return key + "=" + value
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	earthRadiusKm = 6371.0
+	// fiberKmPerMs 是光在光纤中每毫秒传播的距离（约为真空中光速的 2/3）。
+	// 往返时延为 rtt 毫秒时，回应者离本机的直线距离不可能超过 rtt/2 * fiberKmPerMs。
+	fiberKmPerMs = 200.0
+	// defaultRadiusKm 是数据中没有给出精度时假定的位置误差半径
+	defaultRadiusKm = 25.0
+)
+
+// GeoInfo 是 --geo-file 中某个前缀的地理位置，以及根据 RTT 做的光速一致性检查结果
+type GeoInfo struct {
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Label    string  `json:"label,omitempty"`     // 城市等说明
+	RadiusKm float64 `json:"radius_km,omitempty"` // 位置的误差半径，单位公里（GeoLite2 的 accuracy_radius），0 表示没有给出
+
+	DistanceKm float64 `json:"distance_km,omitempty"` // 到 --geo-origin 的大圆距离
+	MinRTTMs   float64 `json:"min_rtt_ms,omitempty"`  // 扣除两端的误差半径后，光在光纤中往返这段距离至少需要的时间
+	Impossible bool    `json:"impossible,omitempty"`  // 实测的最短 RTT 比 MinRTTMs 还短，所标的位置不可能正确
+}
+
+// geoTable 按前缀长度分组保存地理位置，查找时从最长的前缀开始逐个查表，
+// 几百万行的 GeoLite2 City 数据也能很快查到
+type geoTable struct {
+	byLen [8*net.IPv4len + 1]map[string]GeoInfo
+}
+
+// loadGeo 读取 CSV 格式的地理位置数据。第一行是表头时按列名取出
+// network（或 prefix）、latitude、longitude 和可选的 city（或 label）、accuracy_radius，
+// 可以直接使用 MaxMind GeoLite2-City-Blocks-IPv4.csv；没有表头时每行是 prefix,lat,lon,label。
+// # 开头的行是注释，缺少坐标的行和 IPv6 前缀被跳过。
+func loadGeo(path string) (*geoTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.ReuseRecord = true
+	cols := map[string]int{"prefix": 0, "lat": 1, "lon": 2, "label": 3}
+	t := &geoTable{}
+	n := 0
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s 不是有效的 CSV 文件: %w", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		if line == 1 {
+			if header := geoHeader(record); header != nil {
+				cols = header
+				continue
+			}
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		if field("lat") == "" || field("lon") == "" {
+			continue
+		}
+		prefix, err := parsePrefix(field("prefix"))
+		if err != nil {
+			return nil, fmt.Errorf("%s 第 %d 行: %w", path, line, err)
+		}
+		if prefix.IP.To4() == nil {
+			continue
+		}
+		lat, err1 := strconv.ParseFloat(field("lat"), 64)
+		lon, err2 := strconv.ParseFloat(field("lon"), 64)
+		if err1 != nil || err2 != nil || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+			return nil, fmt.Errorf("%s 第 %d 行的坐标 %s,%s 无效", path, line, field("lat"), field("lon"))
+		}
+		radius, _ := strconv.ParseFloat(field("radius"), 64)
+		ones, _ := prefix.Mask.Size()
+		if t.byLen[ones] == nil {
+			t.byLen[ones] = make(map[string]GeoInfo)
+		}
+		t.byLen[ones][string(prefix.IP.To4())] = GeoInfo{Lat: lat, Lon: lon, Label: field("label"), RadiusKm: radius}
+		n++
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%s 中没有任何带坐标的 IPv4 前缀", path)
+	}
+	return t, nil
+}
+
+// geoHeader 识别表头中需要的列，第一行不是表头时返回 nil
+func geoHeader(record []string) map[string]int {
+	names := map[string]string{
+		"network": "prefix", "prefix": "prefix",
+		"latitude": "lat", "lat": "lat",
+		"longitude": "lon", "lon": "lon",
+		"city": "label", "label": "label",
+		"accuracy_radius": "radius",
+	}
+	cols := map[string]int{}
+	for i, name := range record {
+		if col, ok := names[strings.ToLower(strings.TrimSpace(name))]; ok {
+			cols[col] = i
+		}
+	}
+	if _, ok := cols["prefix"]; !ok {
+		return nil
+	}
+	return cols
+}
+
+// lookup 返回包含该地址的最长前缀的地理位置
+func (t *geoTable) lookup(ip net.IP) *GeoInfo {
+	ip4 := ip.To4()
+	if t == nil || ip4 == nil {
+		return nil
+	}
+	for ones := len(t.byLen) - 1; ones >= 0; ones-- {
+		if t.byLen[ones] == nil {
+			continue
+		}
+		key := ip4.Mask(net.CIDRMask(ones, 8*net.IPv4len))
+		if info, ok := t.byLen[ones][string(key)]; ok {
+			return &info
+		}
+	}
+	return nil
+}
+
+// parseGeoOrigin 解析 --geo-origin 的 纬度,经度
+func parseGeoOrigin(s string) (*GeoInfo, error) {
+	lat, lon, ok := strings.Cut(s, ",")
+	la, err1 := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	lo, err2 := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if !ok || err1 != nil || err2 != nil || math.Abs(la) > 90 || math.Abs(lo) > 180 {
+		return nil, fmt.Errorf("--geo-origin %q 无效，格式为 纬度,经度，例如 31.23,121.47", s)
+	}
+	return &GeoInfo{Lat: la, Lon: lo}, nil
+}
+
+// greatCircleKm 用 haversine 公式计算两点之间的大圆距离
+func greatCircleKm(a, b *GeoInfo) float64 {
+	rad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * rad
+	dLon := (b.Lon - a.Lon) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// checkGeo 检查一跳标注的位置与它的最短 RTT 是否符合光速限制。
+// 真实的路径只会比大圆距离更长，排队和 ICMP 生成也只会让 RTT 更大，
+// 所以最短 RTT 比光走完这段距离（扣除两端位置的误差半径）的往返时间还短时，
+// 标注的位置一定是错的；反过来 RTT 很大并不说明位置有问题，这里不做判断。
+func checkGeo(hop *Hop, origin *GeoInfo) {
+	if hop.Geo == nil || origin == nil || hop.Received == 0 {
+		return
+	}
+	hop.Geo.DistanceKm = greatCircleKm(origin, hop.Geo)
+	slack := geoRadius(origin) + geoRadius(hop.Geo)
+	hop.Geo.MinRTTMs = 2 * max(hop.Geo.DistanceKm-slack, 0) / fiberKmPerMs
+	hop.Geo.Impossible = minRTT(hop) < hop.Geo.MinRTTMs
+}
+
+// geoRadius 返回位置的误差半径，数据中没有给出时使用 defaultRadiusKm
+func geoRadius(g *GeoInfo) float64 {
+	if g.RadiusKm > 0 {
+		return g.RadiusKm
+	}
+	return defaultRadiusKm
+}
+
+// describeGeo 返回 geo 列中的说明
+func describeGeo(h *Hop) string {
+	g := h.Geo
+	if g == nil {
+		return ""
+	}
+	where := anon.host(g.Label)
+	if where == "" {
+		where = fmt.Sprintf("%.2f,%.2f", anon.coord(g.Lat), anon.coord(g.Lon))
+	}
+	if g.Impossible {
+		return fmt.Sprintf("[位置 %s 不可能: 相距 %.0f km 至少需要 %.3f ms，实测 %.3f ms]", where, g.DistanceKm, g.MinRTTMs, minRTT(h))
+	}
+	return "[" + where + "]"
+}
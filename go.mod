@@ -4,4 +4,6 @@ go 1.25.1
 
 require golang.org/x/net v0.44.0
 
-require golang.org/x/sys v0.36.0 // indirect
+require golang.org/x/sys v0.36.0
+
+require golang.org/x/text v0.29.0 // indirect
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+
+	"udp-traceroute/icmpreply"
+)
+
+// HopPing 是路径探测完成之后直接 ping 某一跳得到的结果（--hop-ping）
+type HopPing struct {
+	Sent     int       `json:"sent"`                // 发出的 Echo Request 数
+	Received int       `json:"received"`            // 收到的 Echo Reply 数
+	RTTMs    float64   `json:"rtt_ms,omitempty"`    // 最短的直接 RTT，单位毫秒
+	RTTsMs   []float64 `json:"rtts_ms,omitempty"`   // 每个回应的 RTT
+	Rejected int       `json:"rejected,omitempty"`  // 被差错消息拒绝的请求数
+	ReplyTTL int       `json:"reply_ttl,omitempty"` // 第一个 Echo Reply 到达本机时剩余的 TTL（--tunnels 的 RTLA 使用）
+}
+
+// hopPinger 用一个原始套接字依次 ping 路径上的各跳。
+// 路由器生成 Time Exceeded 走的是控制平面，可能被限速或排在低优先级，
+// 而回应 Echo Request 的时延更接近转发路径本身：直接 RTT 明显更短的跳，
+// 表格里偏高的时延多半来自 ICMP 生成而不是转发。
+type hopPinger struct {
+	conn    net.PacketConn
+	traceID uint32
+	seq     uint16
+	buf     []byte
+}
+
+// newHopPinger 创建 ping 各跳使用的原始套接字，要在放弃权限之前创建。
+// 请求使用和 --dest-ping 相同的标识符，主探测的套接字同样不会把回应计入被丢弃的消息。
+func newHopPinger(cfg probeConfig) (*hopPinger, error) {
+	conn, err := cfg.listenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("创建 ping 各跳的套接字失败: %w", err)
+	}
+	return &hopPinger{conn: newTTLConn(conn), traceID: cfg.TraceID, buf: make([]byte, maxPacketLen)}, nil
+}
+
+func (h *hopPinger) Close() error { return h.conn.Close() }
+
+// pingHops 向每个有回应的跳的地址直接发送 n 个 Echo Request，
+// 同一个地址在路径中出现多次时只 ping 一次
+func (h *hopPinger) pingHops(result *TraceResult, n int) {
+	done := map[string]*HopPing{}
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		if hop.Addr == "" {
+			continue
+		}
+		if _, ok := done[hop.Addr]; !ok {
+			done[hop.Addr] = h.ping(net.ParseIP(hop.Addr), n)
+		}
+		hop.Ping = done[hop.Addr]
+	}
+}
+
+// ping 依次发送 n 个 Echo Request，每个最多等待 --timeout
+func (h *hopPinger) ping(addr net.IP, n int) *HopPing {
+	p := &HopPing{}
+	dest := &net.IPAddr{IP: addr}
+	for i := 0; i < n; i++ {
+		h.seq++
+		msg := icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{ID: int(destPingID(h.traceID)), Seq: int(h.seq), Data: stampProbeID(nil, h.seq, h.traceID)},
+		}
+		b, _ := msg.Marshal(nil)
+		sentAt := time.Now()
+		if _, err := h.conn.WriteTo(b, dest); err != nil {
+			continue
+		}
+		p.Sent++
+		rtt, ttl, rejected := h.wait(addr, h.seq, sentAt)
+		switch {
+		case rejected:
+			p.Rejected++
+		case rtt > 0:
+			ms := float64(rtt) / float64(time.Millisecond)
+			p.Received++
+			if p.ReplyTTL == 0 {
+				p.ReplyTTL = ttl
+			}
+			p.RTTsMs = append(p.RTTsMs, ms)
+			if p.RTTMs == 0 || ms < p.RTTMs {
+				p.RTTMs = ms
+			}
+		}
+	}
+	return p
+}
+
+// wait 等待序号为 seq 的请求的回应，最多等到发出后 --timeout。
+// 收到对应的 Echo Reply 时返回 RTT 和它剩余的 TTL，请求被差错消息拒绝时返回 rejected，超时返回 0。
+func (h *hopPinger) wait(addr net.IP, seq uint16, sentAt time.Time) (rtt time.Duration, ttl int, rejected bool) {
+	h.conn.SetReadDeadline(sentAt.Add(timeout))
+	for {
+		n, ttl, peer, err := readFromTTL(h.conn, h.buf)
+		if err != nil {
+			return 0, 0, false
+		}
+		reply, err := icmpreply.Parse(h.buf[:n])
+		if err != nil {
+			continue
+		}
+		switch {
+		case isDestPingReply(h.buf[:n], h.traceID) && peer.(*net.IPAddr).IP.Equal(addr):
+			if binary.BigEndian.Uint16(h.buf[6:8]) == seq {
+				return time.Since(sentAt), ttl, false
+			}
+		case quotesDestPing(reply.Quote, h.traceID) && reply.Quote.Dst.Equal(addr):
+			if binary.BigEndian.Uint16(reply.Quote.Transport[6:8]) == seq {
+				return 0, 0, true
+			}
+		}
+	}
+}
+
+// reportHopPings 把每一跳的直接 ping 结果和 Time Exceeded 的 RTT 放在一起打印
+func reportHopPings(result *TraceResult) {
+	header := false
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		p := hop.Ping
+		if p == nil {
+			continue
+		}
+		if !header {
+			fmt.Println("直接 ping 各跳（与路径探测的 RTT 对比）:")
+			header = true
+		}
+		line := fmt.Sprintf("%2d %-15s ", hop.TTL, anon.addr(hop.Addr))
+		switch {
+		case p.Received == 0 && p.Rejected > 0:
+			line += fmt.Sprintf("ping 被拒绝（%d/%d）", p.Rejected, p.Sent)
+		case p.Received == 0:
+			line += fmt.Sprintf("不回应 ping（%d 次）", p.Sent)
+		default:
+			traced := minRTT(hop)
+			line += fmt.Sprintf("直接 %.3f ms，路径探测 %.3f ms，差 %+.3f ms，ping 丢包 %d/%d",
+				p.RTTMs, traced, traced-p.RTTMs, p.Sent-p.Received, p.Sent)
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// localHosts 是 --hosts-file 加载的地址到主机名的映射，为 nil 时不使用。
+// 给每一跳起名字的地方（host 列、compat 格式、--ptr-check）都先查它再查 DNS，
+// 这样实验室和内网里没有 PTR 记录的路由器也能显示有意义的名字。
+var localHosts *hostsTable
+
+// hostsTable 是按 hosts(5) 格式写的映射文件
+type hostsTable struct {
+	names map[string]string // 规范化的地址 -> 第一个主机名
+}
+
+// loadHostsFile 读取 hosts(5) 格式的文件：每行一个地址，后面跟一个或多个主机名，
+// # 之后是注释。同一个地址出现多次时使用第一次出现的第一个名字。
+func loadHostsFile(path string) (*hostsTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &hostsTable{names: make(map[string]string)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil || len(fields) < 2 {
+			return nil, fmt.Errorf("%s 第 %d 行格式错误，应为“地址 主机名...”", path, line)
+		}
+		if _, ok := t.names[ip.String()]; !ok {
+			t.names[ip.String()] = fields[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// lookup 返回地址在映射文件中的主机名，没有时返回空
+func (t *hostsTable) lookup(addr string) string {
+	if t == nil {
+		return ""
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	return t.names[ip.String()]
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// outputHTML 生成一个自包含的静态 HTML 报告，可以直接附在故障单上，用浏览器打开即可查看
+const outputHTML = "html"
+
+// 每一跳 RTT 折线图的大小，单位像素
+const (
+	sparkWidth  = 120
+	sparkHeight = 24
+)
+
+// htmlHop 是报告中一行需要的数据，地址和名字已经按 --anonymize 处理过
+type htmlHop struct {
+	TTL     int
+	Addr    string
+	Host    string
+	Status  string
+	State   string
+	RTT     string
+	Loss    string
+	Spark   sparkline
+	Details []string // ASN、IXP、IPAM、地址分类等补充信息
+}
+
+// sparkline 是一跳中各探测包 RTT 的折线图，超时的探测包画成底部的红点
+type sparkline struct {
+	Points   string // polyline 的顶点
+	Timeouts []int  // 超时探测包的横坐标
+	Dots     []sparkDot
+}
+
+type sparkDot struct{ X, Y int }
+
+// writeHTML 把结果写成 HTML 报告，只包含满足 filter 的跳。
+// 报告里没有地图：结果中没有地理位置数据，画不出来。
+func writeHTML(w io.Writer, result *TraceResult, filter *hopFilter, resolve bool) error {
+	// 所有跳使用同一个纵坐标，折线的高低在跳与跳之间可以直接比较
+	maxRTT := 0.0
+	for _, hop := range result.Hops {
+		for _, p := range hop.Probes {
+			maxRTT = max(maxRTT, p.RTTMs)
+		}
+	}
+	var hops []htmlHop
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		if !filter.allows(hop) {
+			continue
+		}
+		if resolve && !hop.Timeout {
+			lookupHost(hop)
+		}
+		hops = append(hops, newHTMLHop(hop, maxRTT))
+	}
+	data := struct {
+		Target, DestIP, Started, Summary string
+		Hops                             []htmlHop
+		Width, Height                    int
+		Annotations                      []string
+	}{
+		Target:  anon.addr(result.Target),
+		DestIP:  anon.addr(result.DestIP),
+		Started: result.StartedAt.Local().Format(time.DateTime),
+		Summary: summarize(result),
+		Hops:    hops,
+		Width:   sparkWidth,
+		Height:  sparkHeight,
+	}
+	if result.Loop != nil {
+		data.Annotations = append(data.Annotations, "检测到路由环路: "+describeLoop(result.Loop))
+	}
+	return htmlReport.Execute(w, data)
+}
+
+func newHTMLHop(hop *Hop, maxRTT float64) htmlHop {
+	h := htmlHop{TTL: hop.TTL, State: hop.State, Loss: strings.TrimSpace(lossNote(hop))}
+	if hop.Timeout {
+		h.Status = "* * *"
+	} else {
+		h.Addr = anon.addr(hop.Addr)
+		h.Host = anon.host(hop.Host)
+		h.Status = hopStatus(hop)
+		h.RTT = fmt.Sprintf("%.3f ms", hop.RTTMs)
+	}
+	if hop.ASN != 0 {
+		h.Details = append(h.Details, fmt.Sprintf("AS%d", anon.asn(hop.ASN)))
+	}
+	if hop.RPKI == rpkiInvalid {
+		h.Details = append(h.Details, "RPKI 无效: "+anon.prefix(hop.Prefix))
+	}
+	if hop.IPAM != nil {
+		h.Details = append(h.Details, strings.Trim(describeIPAM(hop.IPAM), "[]"))
+	}
+	if hop.IXP != "" {
+		h.Details = append(h.Details, "IXP: "+hop.IXP)
+	}
+	if hop.Class != "" {
+		h.Details = append(h.Details, classDescriptions[hop.Class])
+	}
+	if hop.PTRMismatch {
+		h.Details = append(h.Details, "PTR 不符")
+	}
+	h.Details = append(h.Details, anon.labels(hop.Labels)...)
+	h.Spark = newSparkline(hop.Probes, maxRTT)
+	return h
+}
+
+// newSparkline 按发送顺序把每个探测包画在折线图上
+func newSparkline(probes []ProbeRecord, maxRTT float64) sparkline {
+	var s sparkline
+	if len(probes) == 0 {
+		return s
+	}
+	step := float64(sparkWidth-4) / float64(max(len(probes)-1, 1))
+	var points []string
+	for i, p := range probes {
+		x := 2 + int(float64(i)*step)
+		if p.Timeout {
+			s.Timeouts = append(s.Timeouts, x)
+			continue
+		}
+		y := sparkHeight - 2
+		if maxRTT > 0 {
+			y -= int(p.RTTMs / maxRTT * float64(sparkHeight-4))
+		}
+		points = append(points, fmt.Sprintf("%d,%d", x, y))
+		s.Dots = append(s.Dots, sparkDot{x, y})
+	}
+	s.Points = strings.Join(points, " ")
+	return s
+}
+
+// htmlReport 是报告的模板。样式全部内联，不引用任何外部资源。
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>traceroute 到 {{.Target}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: left; vertical-align: middle; }
+th { background: #f4f4f4; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+tr.timeout td { color: #999; }
+tr.unreachable td, tr.loop td { background: #fff3f0; }
+.detail { display: inline-block; margin-right: 6px; padding: 0 4px; border-radius: 3px; background: #eef; font-size: 90%; }
+.summary { margin: 1em 0; }
+</style>
+</head>
+<body>
+<h1>traceroute 到 {{.Target}} ({{.DestIP}})</h1>
+<p>开始于 {{.Started}}</p>
+<p class="summary">{{.Summary}}</p>
+{{range .Annotations}}<p>{{.}}</p>
+{{end}}<table>
+<tr><th>跳</th><th>地址</th><th>主机名</th><th>状态</th><th>RTT</th><th>丢包</th><th>各探测包 RTT</th><th>补充信息</th></tr>
+{{range .Hops}}<tr class="{{.State}}">
+<td class="num">{{.TTL}}</td><td>{{.Addr}}</td><td>{{.Host}}</td><td>{{.Status}}</td><td class="num">{{.RTT}}</td><td class="num">{{.Loss}}</td>
+<td><svg width="{{$.Width}}" height="{{$.Height}}" viewBox="0 0 {{$.Width}} {{$.Height}}">{{if .Spark.Points}}<polyline points="{{.Spark.Points}}" fill="none" stroke="#36c" stroke-width="1.5"/>{{end}}{{range .Spark.Dots}}<circle cx="{{.X}}" cy="{{.Y}}" r="1.5" fill="#36c"/>{{end}}{{range .Spark.Timeouts}}<circle cx="{{.}}" cy="{{$.Height}}" r="2" transform="translate(0,-2)" fill="#c33"/>{{end}}</svg></td>
+<td>{{range .Details}}<span class="detail">{{.}}</span>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
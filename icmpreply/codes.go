@@ -0,0 +1,91 @@
+package icmpreply
+
+import (
+	"fmt"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Destination Unreachable 的代码（RFC 792、RFC 1812）
+const (
+	CodeNetUnreachable        = 0
+	CodeHostUnreachable       = 1
+	CodeProtocolUnreachable   = 2
+	CodePortUnreachable       = 3
+	CodeFragNeeded            = 4
+	CodeSourceRouteFailed     = 5
+	CodeNetProhibited         = 9
+	CodeHostProhibited        = 10
+	CodeAdminProhibited       = 13
+	CodeHostPrecedence        = 14
+	CodePrecedenceCutoff      = 15
+	CodeTTLExceededInTransit  = 0 // Time Exceeded 的代码：传输途中TTL耗尽
+	CodeFragReassemblyTimeout = 1 // Time Exceeded 的代码：分片重组超时
+)
+
+// unreachableNames 是 Destination Unreachable 各代码的名称
+var unreachableNames = map[int]string{
+	CodeNetUnreachable:      "Network Unreachable",
+	CodeHostUnreachable:     "Host Unreachable",
+	CodeProtocolUnreachable: "Protocol Unreachable",
+	CodePortUnreachable:     "Port Unreachable",
+	CodeFragNeeded:          "Fragmentation Needed",
+	CodeSourceRouteFailed:   "Source Route Failed",
+	6:                       "Destination Network Unknown",
+	7:                       "Destination Host Unknown",
+	8:                       "Source Host Isolated",
+	CodeNetProhibited:       "Network Administratively Prohibited",
+	CodeHostProhibited:      "Host Administratively Prohibited",
+	11:                      "Network Unreachable for TOS",
+	12:                      "Host Unreachable for TOS",
+	CodeAdminProhibited:     "Communication Administratively Prohibited",
+	CodeHostPrecedence:      "Host Precedence Violation",
+	CodePrecedenceCutoff:    "Precedence Cutoff in Effect",
+}
+
+// Describe 返回ICMP类型和代码的名称
+func Describe(t ipv4.ICMPType, code int) string {
+	switch t {
+	case ipv4.ICMPTypeDestinationUnreachable:
+		if name, ok := unreachableNames[code]; ok {
+			return name
+		}
+		return fmt.Sprintf("Destination Unreachable (code %d)", code)
+	case ipv4.ICMPTypeTimeExceeded:
+		if code == CodeFragReassemblyTimeout {
+			return "Fragment Reassembly Time Exceeded"
+		}
+		return "Time Exceeded"
+	}
+	return t.String()
+}
+
+// Annotation 返回经典 traceroute 在不可达回应后面附加的标记，
+// 例如 !H 表示主机不可达、!X 表示被管理性禁止。
+// 端口不可达是正常到达目标的标志，不加标记；其他类型的消息也没有标记。
+func Annotation(t ipv4.ICMPType, code int) string {
+	if t != ipv4.ICMPTypeDestinationUnreachable {
+		return ""
+	}
+	switch code {
+	case CodePortUnreachable:
+		return ""
+	case CodeNetUnreachable, 6, 11:
+		return "!N"
+	case CodeHostUnreachable, 7, 12:
+		return "!H"
+	case CodeProtocolUnreachable:
+		return "!P"
+	case CodeFragNeeded:
+		return "!F"
+	case CodeSourceRouteFailed:
+		return "!S"
+	case CodeNetProhibited, CodeHostProhibited, CodeAdminProhibited:
+		return "!X"
+	case CodeHostPrecedence:
+		return "!V"
+	case CodePrecedenceCutoff:
+		return "!C"
+	}
+	return fmt.Sprintf("!<%d>", code)
+}
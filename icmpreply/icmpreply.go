@@ -0,0 +1,162 @@
+// Package icmpreply 解析 traceroute 收到的 ICMPv4 回应。
+//
+// 它只处理字节，不涉及任何套接字：Parse 负责长度和校验和检查、
+// 类型/代码的含义、被引用的原始数据报以及 RFC 4884 扩展（例如 MPLS 标签栈）。
+// 输入来自网络，可能是任意内容，因此 Parse 对任何输入都只会返回错误而不会 panic。
+package icmpreply
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// HeaderLen 是ICMP头部（类型、代码、校验和以及4字节的附加字段）的长度
+const HeaderLen = 8
+
+// Parse 可能返回的错误
+var (
+	ErrTooShort      = errors.New("icmpreply: 消息短于ICMP头部")
+	ErrChecksum      = errors.New("icmpreply: 校验和错误")
+	ErrQuoteTooShort = errors.New("icmpreply: 差错消息引用的数据不足一个IP头部")
+)
+
+// Reply 是解析后的ICMP回应
+type Reply struct {
+	Type ipv4.ICMPType
+	Code int
+
+	// NextHopMTU 是 Destination Unreachable（需要分片）中路由器报告的下一跳 MTU（RFC 1191），
+	// 其他消息以及不支持 RFC 1191 的老设备发出的消息为 0
+	NextHopMTU int
+
+	// Quote 是差错消息（Time Exceeded、Destination Unreachable、Parameter Problem）
+	// 引用的原始数据报，其他类型的消息为 nil
+	Quote *Quote
+
+	// Extensions 是 RFC 4884 多段消息中携带的扩展对象，
+	// 例如 RFC 4950 的 MPLS 标签栈和 RFC 5837 的接口信息
+	Extensions []icmp.Extension
+}
+
+// Quote 是ICMP差错消息中引用的原始数据报。
+// 有的设备只引用IP头加8个字节（RFC 792 的最低要求），有的引用被截断，
+// 还有的引用的是非首个分片，因此IP头之外的字段都可能缺失。
+type Quote struct {
+	Src        net.IP
+	Dst        net.IP
+	Protocol   int
+	TTL        int // 原始数据报到达发出差错消息的设备时剩余的TTL
+	ID         int // IP标识字段
+	FragOffset int // 分片偏移，单位字节
+
+	// 以下字段只在引用中包含传输层头部时有效
+	HasPorts  bool
+	SrcPort   int
+	DstPort   int
+	UDPLength int    // UDP长度字段，引用不足8字节或不是UDP时为0
+	Payload   []byte // 引用中包含的传输层负载（UDP头之后的部分），可能不完整
+	Transport []byte // IP头之后引用的全部字节，用于解析UDP以外的协议（例如ICMP Echo、TCP），可能不完整
+}
+
+// Parse 校验并解析一条ICMPv4消息，b 必须恰好是收到的消息（不含IP头）
+func Parse(b []byte) (*Reply, error) {
+	if len(b) < HeaderLen {
+		return nil, ErrTooShort
+	}
+	if Checksum(b) != 0 {
+		return nil, ErrChecksum
+	}
+	r := &Reply{Type: ipv4.ICMPType(b[0]), Code: int(b[1])}
+	if !r.IsError() {
+		return r, nil
+	}
+	if r.Type == ipv4.ICMPTypeDestinationUnreachable && r.Code == CodeFragNeeded {
+		r.NextHopMTU = int(binary.BigEndian.Uint16(b[6:8]))
+	}
+	if len(b) < HeaderLen+ipv4.HeaderLen {
+		return nil, ErrQuoteTooShort
+	}
+
+	// 先交给 icmp.ParseMessage 处理扩展。有的设备发出的差错消息带有
+	// RFC 4884 长度字段，却又把引用的数据截短了，ParseMessage 会因此报错；
+	// 这种情况下退而把头部之后的全部字节当作引用的数据。
+	data := b[HeaderLen:]
+	if msg, err := icmp.ParseMessage(1, b); err == nil {
+		switch body := msg.Body.(type) {
+		case *icmp.TimeExceeded:
+			data, r.Extensions = body.Data, body.Extensions
+		case *icmp.DstUnreach:
+			data, r.Extensions = body.Data, body.Extensions
+		case *icmp.ParamProb:
+			data, r.Extensions = body.Data, body.Extensions
+		}
+	}
+	r.Quote = parseQuote(data)
+	return r, nil
+}
+
+// IsError 报告该消息是否为引用原始数据报的差错消息
+func (r *Reply) IsError() bool {
+	switch r.Type {
+	case ipv4.ICMPTypeTimeExceeded, ipv4.ICMPTypeDestinationUnreachable, ipv4.ICMPTypeParameterProblem:
+		return true
+	}
+	return false
+}
+
+// parseQuote 解析引用的IP头以及能够取得的传输层字段。
+// 这里没有使用 ipv4.ParseHeader：它在部分平台上会按主机字节序解释长度和分片字段，
+// 那是针对原始套接字收到的IP头的特殊处理，并不适用于ICMP中引用的IP头。
+func parseQuote(data []byte) *Quote {
+	if len(data) < ipv4.HeaderLen || data[0]>>4 != ipv4.Version {
+		return nil
+	}
+	hdrLen := int(data[0]&0x0f) * 4
+	if hdrLen < ipv4.HeaderLen {
+		return nil
+	}
+	q := &Quote{
+		Src:        net.IPv4(data[12], data[13], data[14], data[15]),
+		Dst:        net.IPv4(data[16], data[17], data[18], data[19]),
+		Protocol:   int(data[9]),
+		TTL:        int(data[8]),
+		ID:         int(binary.BigEndian.Uint16(data[4:6])),
+		FragOffset: int(binary.BigEndian.Uint16(data[6:8])&0x1fff) * 8,
+	}
+
+	// 非首个分片里没有传输层头部；IP选项被截断时也拿不到端口
+	if q.FragOffset != 0 || len(data) < hdrLen+4 {
+		return q
+	}
+	l4 := data[hdrLen:]
+	q.Transport = l4
+	q.HasPorts = true
+	q.SrcPort = int(binary.BigEndian.Uint16(l4[0:2]))
+	q.DstPort = int(binary.BigEndian.Uint16(l4[2:4]))
+	const udpHeaderLen = 8
+	if q.Protocol == 17 && len(l4) >= udpHeaderLen {
+		q.UDPLength = int(binary.BigEndian.Uint16(l4[4:6]))
+		q.Payload = l4[udpHeaderLen:]
+	}
+	return q
+}
+
+// Checksum 计算 RFC 1071 互联网校验和。
+// 对包含正确校验和字段的数据计算，结果应当为 0。
+func Checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,231 @@
+package icmpreply
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// quotedIP 构造一个被引用的IPv4头：源 192.0.2.1，目标 198.51.100.7
+func quotedIP(protocol, ttl, id, fragOffset int) []byte {
+	h := make([]byte, ipv4.HeaderLen)
+	h[0] = ipv4.Version<<4 | ipv4.HeaderLen/4
+	binary.BigEndian.PutUint16(h[4:6], uint16(id))
+	binary.BigEndian.PutUint16(h[6:8], uint16(fragOffset/8))
+	h[8] = byte(ttl)
+	h[9] = byte(protocol)
+	copy(h[12:16], []byte{192, 0, 2, 1})
+	copy(h[16:20], []byte{198, 51, 100, 7})
+	return h
+}
+
+// quotedUDP 构造被引用的UDP头和负载
+func quotedUDP(srcPort, dstPort int, payload []byte) []byte {
+	u := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(u[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(u[2:4], uint16(dstPort))
+	binary.BigEndian.PutUint16(u[4:6], uint16(8+len(payload)))
+	return append(u, payload...)
+}
+
+func concat(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+// marshal 编码一条ICMPv4消息，校验和由 icmp.Message 计算
+func marshal(t *testing.T, typ ipv4.ICMPType, code int, body icmp.MessageBody) []byte {
+	t.Helper()
+	b, err := (&icmp.Message{Type: typ, Code: code, Body: body}).Marshal(nil)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return b
+}
+
+// fixChecksum 在改动消息之后重新计算校验和
+func fixChecksum(b []byte) []byte {
+	b[2], b[3] = 0, 0
+	binary.BigEndian.PutUint16(b[2:4], Checksum(b))
+	return b
+}
+
+func TestParseQuotes(t *testing.T) {
+	full := concat(quotedIP(17, 1, 0x1234, 0), quotedUDP(40000, 33434, []byte("probe")))
+	tests := []struct {
+		name      string
+		data      []byte
+		wantQuote bool
+		hasPorts  bool
+		udpLength int
+		payload   string
+	}{
+		{"完整的UDP引用", full, true, true, 13, "probe"},
+		{"只有IP头和8字节", full[:ipv4.HeaderLen+8], true, true, 13, ""},
+		{"只有端口", full[:ipv4.HeaderLen+4], true, true, 0, ""},
+		{"只有IP头", full[:ipv4.HeaderLen], true, false, 0, ""},
+		{"非首个分片", concat(quotedIP(17, 1, 0x1234, 1480), quotedUDP(40000, 33434, nil)), true, false, 0, ""},
+		{"IP选项被截断", concat([]byte{ipv4.Version<<4 | 15}, full[1:ipv4.HeaderLen+8]), true, false, 0, ""},
+		{"引用的不是IPv4", concat([]byte{0x60}, full[1:]), false, false, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := marshal(t, ipv4.ICMPTypeTimeExceeded, CodeTTLExceededInTransit, &icmp.TimeExceeded{Data: tt.data})
+			r, err := Parse(b)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if (r.Quote != nil) != tt.wantQuote {
+				t.Fatalf("Quote = %v，期望存在: %v", r.Quote, tt.wantQuote)
+			}
+			q := r.Quote
+			if q == nil {
+				return
+			}
+			if !q.Dst.Equal([]byte{198, 51, 100, 7}) || q.Protocol != 17 || q.TTL != 1 {
+				t.Errorf("IP头字段错误: %+v", q)
+			}
+			if q.HasPorts != tt.hasPorts || q.UDPLength != tt.udpLength || string(q.Payload) != tt.payload {
+				t.Errorf("HasPorts=%v UDPLength=%d Payload=%q，期望 %v %d %q", q.HasPorts, q.UDPLength, q.Payload, tt.hasPorts, tt.udpLength, tt.payload)
+			}
+			if q.HasPorts && (q.SrcPort != 40000 || q.DstPort != 33434) {
+				t.Errorf("端口 %d->%d 错误", q.SrcPort, q.DstPort)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	good := marshal(t, ipv4.ICMPTypeTimeExceeded, 0, &icmp.TimeExceeded{Data: concat(quotedIP(17, 1, 1, 0), quotedUDP(1, 2, nil))})
+	badChecksum := append([]byte(nil), good...)
+	badChecksum[2] ^= 0xff
+	corrupted := append([]byte(nil), good...)
+	corrupted[len(corrupted)-1] ^= 0x01
+	tests := []struct {
+		name string
+		b    []byte
+		want error
+	}{
+		{"空消息", nil, ErrTooShort},
+		{"短于ICMP头部", good[:HeaderLen-1], ErrTooShort},
+		{"校验和字段错误", badChecksum, ErrChecksum},
+		{"内容被改动", corrupted, ErrChecksum},
+		{"引用不足一个IP头", fixChecksum(append([]byte(nil), good[:HeaderLen+ipv4.HeaderLen-1]...)), ErrQuoteTooShort},
+		{"没有引用", fixChecksum(append([]byte(nil), good[:HeaderLen]...)), ErrQuoteTooShort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.b); !errors.Is(err, tt.want) {
+				t.Errorf("Parse 返回 %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExtensions(t *testing.T) {
+	quote := concat(quotedIP(17, 1, 7, 0), quotedUDP(40000, 33434, []byte("probe")))
+	stack := &icmp.MPLSLabelStack{Class: 1, Type: 1, Labels: []icmp.MPLSLabel{{Label: 24001, TC: 0, S: true, TTL: 1}}}
+	b := marshal(t, ipv4.ICMPTypeTimeExceeded, 0, &icmp.TimeExceeded{Data: quote, Extensions: []icmp.Extension{stack}})
+
+	r, err := Parse(b)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(r.Extensions) != 1 {
+		t.Fatalf("得到 %d 个扩展，期望 1 个", len(r.Extensions))
+	}
+	got, ok := r.Extensions[0].(*icmp.MPLSLabelStack)
+	if !ok || len(got.Labels) != 1 || got.Labels[0].Label != 24001 || got.Labels[0].TTL != 1 {
+		t.Fatalf("MPLS 标签栈错误: %#v", r.Extensions[0])
+	}
+	// RFC 4884 要求引用补齐到 128 字节，补齐的部分不应当被当作扩展
+	if r.Quote == nil || r.Quote.SrcPort != 40000 || !bytes.HasPrefix(r.Quote.Payload, []byte("probe")) {
+		t.Fatalf("带扩展时引用解析错误: %+v", r.Quote)
+	}
+
+	// 长度字段声明的引用比实际的长：ParseMessage 失败，退而把全部字节当作引用
+	truncated := append([]byte(nil), b[:HeaderLen+len(quote)]...)
+	truncated[5] = 128 / 4
+	r, err = Parse(fixChecksum(truncated))
+	if err != nil {
+		t.Fatalf("截断的多段消息: %v", err)
+	}
+	if r.Extensions != nil || r.Quote == nil || r.Quote.DstPort != 33434 {
+		t.Fatalf("截断的多段消息解析错误: %+v %v", r.Quote, r.Extensions)
+	}
+}
+
+func TestParseNextHopMTU(t *testing.T) {
+	quote := concat(quotedIP(17, 5, 9, 0), quotedUDP(40000, 33434, nil))
+	b := marshal(t, ipv4.ICMPTypeDestinationUnreachable, CodeFragNeeded, &icmp.DstUnreach{Data: quote})
+	binary.BigEndian.PutUint16(b[6:8], 1400)
+	r, err := Parse(fixChecksum(b))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if r.NextHopMTU != 1400 {
+		t.Errorf("NextHopMTU = %d，期望 1400", r.NextHopMTU)
+	}
+
+	// 其他代码的不可达消息没有下一跳 MTU
+	b = marshal(t, ipv4.ICMPTypeDestinationUnreachable, CodePortUnreachable, &icmp.DstUnreach{Data: quote})
+	if r, err := Parse(b); err != nil || r.NextHopMTU != 0 {
+		t.Errorf("端口不可达: NextHopMTU = %v, err = %v", r, err)
+	}
+}
+
+func TestParseNonError(t *testing.T) {
+	b := marshal(t, ipv4.ICMPTypeEchoReply, 0, &icmp.Echo{ID: 1, Seq: 2, Data: []byte("x")})
+	r, err := Parse(b)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if r.IsError() || r.Quote != nil {
+		t.Errorf("Echo Reply 不应当有引用: %+v", r)
+	}
+}
+
+// FuzzParse 检查 Parse 对任意输入都不会 panic，并且解析结果自洽
+func FuzzParse(f *testing.F) {
+	quote := concat(quotedIP(17, 1, 7, 0), quotedUDP(40000, 33434, []byte("probe")))
+	seeds := []icmp.Message{
+		{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quote}},
+		{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quote, Extensions: []icmp.Extension{
+			&icmp.MPLSLabelStack{Class: 1, Type: 1, Labels: []icmp.MPLSLabel{{Label: 16, S: true, TTL: 1}}},
+		}}},
+		{Type: ipv4.ICMPTypeDestinationUnreachable, Code: CodePortUnreachable, Body: &icmp.DstUnreach{Data: quote}},
+		{Type: ipv4.ICMPTypeParameterProblem, Body: &icmp.ParamProb{Pointer: 8, Data: quote[:ipv4.HeaderLen]}},
+		{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: 1}},
+	}
+	for _, m := range seeds {
+		b, err := m.Marshal(nil)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{11, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		r, err := Parse(b)
+		if err != nil {
+			if r != nil {
+				t.Fatalf("出错时仍然返回了结果: %+v", r)
+			}
+			return
+		}
+		if Checksum(b) != 0 {
+			t.Fatalf("校验和错误的消息通过了检查")
+		}
+		if r.Quote != nil {
+			if !r.IsError() {
+				t.Fatalf("类型 %v 不是差错消息却有引用", r.Type)
+			}
+			if r.Quote.HasPorts && len(r.Quote.Transport) < 4 {
+				t.Fatalf("HasPorts 为真但传输层数据只有 %d 字节", len(r.Quote.Transport))
+			}
+		}
+	})
+}
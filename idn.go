@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/net/idna"
+)
+
+// 国际化域名：DNS 中只有 ASCII 的 punycode 形式（RFC 3492，标签前加 xn--），
+// 用户输入的 Unicode 域名先按 IDNA 的查询规则（UTS #46）映射、规范化并校验，
+// 再转换成 punycode 解析，输出时两种形式都显示。
+// 映射包括小写化、NFC 规范化，以及中文和日文输入法常见的全角句点和全角字母。
+
+// toASCIIName 把国际化域名转换成 DNS 查询使用的形式（如 例子.测试 → xn--fsqu00a.xn--0zwm56d）。
+// 纯 ASCII 的目标原样返回，带下划线之类的名字照常解析。
+func toASCIIName(name string) (string, error) {
+	if isASCII(name) {
+		return name, nil
+	}
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("'%s' 不是有效的国际化域名: %w", name, err)
+	}
+	return ascii, nil
+}
+
+// toUnicodeName 把名字中 xn-- 开头的标签还原成 Unicode，无法解码时保持原样
+func toUnicodeName(name string) string {
+	if s, err := idna.Lookup.ToUnicode(name); err == nil {
+		return s
+	}
+	return name
+}
+
+// asciiTarget 返回目标与输入不同的 punycode 形式，记入结果的 target_ascii；相同或无法转换时为空
+func asciiTarget(target string) string {
+	if ascii, err := toASCIIName(target); err == nil && ascii != target {
+		return ascii
+	}
+	return ""
+}
+
+// describeName 在目标后面附上它的另一种形式：输入 Unicode 域名时附上 punycode，
+// 输入 punycode 时附上便于阅读的 Unicode 形式。假名化时目标整个被隐去，不附加。
+func describeName(target string) string {
+	if anon != nil {
+		return anon.addr(target)
+	}
+	if ascii := asciiTarget(target); ascii != "" {
+		return fmt.Sprintf("%s [%s]", target, ascii)
+	}
+	if unicode := toUnicodeName(target); unicode != target {
+		return fmt.Sprintf("%s [%s]", target, unicode)
+	}
+	return target
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// IPAMInfo 是 IPAM 导出文件中某个前缀的描述
+type IPAMInfo struct {
+	Label string `json:"label"`           // 设备或网段的名字，例如 core-rtr-LAX
+	Site  string `json:"site,omitempty"`  // 所在站点或机房
+	Owner string `json:"owner,omitempty"` // 负责人或团队
+}
+
+// ipamTable 保存用户从 IPAM 导出的前缀，用于给内网的跳加上有意义的名字
+type ipamTable struct {
+	prefixes []ipamPrefix
+}
+
+type ipamPrefix struct {
+	net  *net.IPNet
+	info IPAMInfo
+}
+
+// loadIPAM 读取 CSV 格式的 IPAM 导出文件，每行是 prefix,label,site,owner，
+// site 和 owner 可以省略。prefix 可以是单个地址；第一行是表头时跳过，# 开头的行是注释。
+func loadIPAM(path string) (*ipamTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	t := &ipamTable{}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s 不是有效的 CSV 文件: %w", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		if line == 1 && strings.EqualFold(record[0], "prefix") {
+			continue
+		}
+		if len(record) < 2 || record[1] == "" {
+			return nil, fmt.Errorf("%s 第 %d 行缺少 label，格式应为 prefix,label,site,owner", path, line)
+		}
+		n, err := parsePrefix(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s 第 %d 行: %w", path, line, err)
+		}
+		info := IPAMInfo{Label: record[1]}
+		if len(record) > 2 {
+			info.Site = record[2]
+		}
+		if len(record) > 3 {
+			info.Owner = record[3]
+		}
+		t.prefixes = append(t.prefixes, ipamPrefix{net: n, info: info})
+	}
+	if len(t.prefixes) == 0 {
+		return nil, fmt.Errorf("%s 中没有任何前缀", path)
+	}
+	return t, nil
+}
+
+// parsePrefix 解析 CIDR 前缀，单个地址当作只包含它自己的前缀
+func parsePrefix(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%q 不是有效的地址或前缀", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("%q 不是有效的地址或前缀", s)
+	}
+	return n, nil
+}
+
+// lookup 返回包含该地址的前缀的描述，有多个前缀包含它时取最长前缀
+func (t *ipamTable) lookup(ip net.IP) *IPAMInfo {
+	if t == nil {
+		return nil
+	}
+	var best *IPAMInfo
+	bestLen := -1
+	for i, p := range t.prefixes {
+		if ones, _ := p.net.Mask.Size(); ones > bestLen && p.net.Contains(ip) {
+			best, bestLen = &t.prefixes[i].info, ones
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	info := *best
+	return &info
+}
+
+// describeIPAM 返回表格中显示的描述：名字、站点和负责人
+func describeIPAM(info *IPAMInfo) string {
+	parts := []string{anon.host(info.Label)}
+	for _, s := range []string{info.Site, info.Owner} {
+		if s != "" {
+			parts = append(parts, anon.host(s))
+		}
+	}
+	return "[" + strings.Join(parts, " | ") + "]"
+}
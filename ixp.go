@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+)
+
+// ixpTable 保存 IXP 交换网段，用于标注路径在哪里经过了公共交换中心
+type ixpTable struct {
+	prefixes []ixpPrefix
+}
+
+// ixpPrefix 是一个 IXP 的对等网段
+type ixpPrefix struct {
+	net  *net.IPNet
+	name string
+}
+
+// peeringDBDump 是 PeeringDB 导出数据中用到的部分。
+// 它和 CAIDA 归档的每日快照格式相同，也可以用 PeeringDB API 的
+// /api/ix、/api/ixlan、/api/ixpfx 三个接口的返回值拼成：
+//
+//	{"ix": {"data": [...]}, "ixlan": {"data": [...]}, "ixpfx": {"data": [...]}}
+type peeringDBDump struct {
+	IX struct {
+		Data []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"data"`
+	} `json:"ix"`
+	IXLan struct {
+		Data []struct {
+			ID   int `json:"id"`
+			IXID int `json:"ix_id"`
+		} `json:"data"`
+	} `json:"ixlan"`
+	IXPfx struct {
+		Data []struct {
+			IXLanID  int    `json:"ixlan_id"`
+			Protocol string `json:"protocol"`
+			Prefix   string `json:"prefix"`
+		} `json:"data"`
+	} `json:"ixpfx"`
+}
+
+// loadIXPs 读取 PeeringDB 导出文件，把每个IPv4对等网段对应到它所属的 IXP 名字
+func loadIXPs(path string) (*ixpTable, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var dump peeringDBDump
+	if err := json.Unmarshal(body, &dump); err != nil {
+		return nil, fmt.Errorf("%s 不是有效的 PeeringDB 导出文件: %w", path, err)
+	}
+
+	names := make(map[int]string)
+	for _, ix := range dump.IX.Data {
+		names[ix.ID] = ix.Name
+	}
+	lanIX := make(map[int]int)
+	for _, lan := range dump.IXLan.Data {
+		lanIX[lan.ID] = lan.IXID
+	}
+	t := &ixpTable{}
+	for _, pfx := range dump.IXPfx.Data {
+		if pfx.Protocol != "IPv4" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(pfx.Prefix)
+		if err != nil {
+			continue
+		}
+		name := names[lanIX[pfx.IXLanID]]
+		if name == "" {
+			name = fmt.Sprintf("IXP ixlan %d", pfx.IXLanID)
+		}
+		t.prefixes = append(t.prefixes, ixpPrefix{net: n, name: name})
+	}
+	if len(t.prefixes) == 0 {
+		return nil, fmt.Errorf("%s 中没有IPv4的 IXP 网段", path)
+	}
+	return t, nil
+}
+
+// lookup 返回包含该地址的 IXP 名字，有多个网段包含它时取最长前缀
+func (t *ixpTable) lookup(ip net.IP) string {
+	if t == nil {
+		return ""
+	}
+	best, bestLen := "", -1
+	for _, p := range t.prefixes {
+		if ones, _ := p.net.Mask.Size(); ones > bestLen && p.net.Contains(ip) {
+			best, bestLen = p.name, ones
+		}
+	}
+	return best
+}
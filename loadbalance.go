@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// 负载均衡的分类结果
+const (
+	lbPerFlow   = "per-flow"       // 按流（五元组哈希）的 ECMP，同一条流总是走同一个下一跳
+	lbPerPacket = "per-packet"     // 逐包负载均衡，同一条流的包也会被分散到不同下一跳
+	lbUnstable  = "route-unstable" // 测量期间路由发生了变化，而不是负载均衡
+)
+
+// lbDescriptions 是各分类在终端上的说明
+var lbDescriptions = map[string]string{
+	lbPerFlow:   "按流负载均衡 (per-flow ECMP)",
+	lbPerPacket: "逐包负载均衡 (per-packet)",
+	lbUnstable:  "路由不稳定",
+}
+
+// classifyLoadBalancing 判断某个TTL上是否存在负载均衡以及它的类型。
+//
+// 先发送 n 个目标端口各不相同（即流标识各不相同）的探测包，
+// 如果只有一个响应者，说明这一跳没有分叉，直接返回空结果。
+// 否则再发送 n 个流标识完全相同的探测包：
+//   - 相同的流只得到一个响应者：按流 ECMP；
+//   - 相同的流在多个响应者之间来回切换：逐包负载均衡；
+//   - 相同的流只切换了一次且不再回来：更像是测量期间路由发生了变化。
+func classifyLoadBalancing(p prober, ttl int, destIP net.IP, n int) (kind string, responders []string) {
+	var varied []string
+	for i := 0; i < n; i++ {
+		reply, err := p.probe(ttl, &net.UDPAddr{IP: destIP, Port: destPort + 1 + i}, nil)
+		if err == nil && reply != nil {
+			varied = append(varied, reply.Peer.String())
+		}
+	}
+	responders = distinct(varied)
+	if len(responders) <= 1 {
+		return "", nil
+	}
+
+	var fixed []string
+	for i := 0; i < n; i++ {
+		reply, err := p.probe(ttl, &net.UDPAddr{IP: destIP, Port: destPort}, nil)
+		if err == nil && reply != nil {
+			fixed = append(fixed, reply.Peer.String())
+		}
+	}
+	// 固定流的探测也可能发现新的响应者，一并记录
+	responders = distinct(append(responders, fixed...))
+
+	switch {
+	case len(distinct(fixed)) <= 1:
+		return lbPerFlow, responders
+	case transitions(fixed) == 1:
+		return lbUnstable, responders
+	default:
+		return lbPerPacket, responders
+	}
+}
+
+// describeLoadBalancing 生成附加在某一跳下面的负载均衡说明
+func describeLoadBalancing(kind string, responders []string) string {
+	return lbDescriptions[kind] + "，响应者: " + strings.Join(anon.addrs(responders), ", ")
+}
+
+// distinct 按出现顺序去重
+func distinct(addrs []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, a := range addrs {
+		if !seen[a] {
+			seen[a] = true
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// transitions 统计序列中相邻两项不同的次数
+func transitions(seq []string) int {
+	n := 0
+	for i := 1; i < len(seq); i++ {
+		if seq[i] != seq[i-1] {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// detectLoop 检查路径末尾是否出现了转发环路：最后一跳的地址在更早的TTL上出现过，
+// 并且两次出现之间的那一段地址序列已经完整地重复了两遍（A A A、A B A B A 等）。
+// 只重复一次可能是目标或某些设备不递减TTL造成的，不足以下结论。
+// 确认是环路时返回环路中的地址，按出现的顺序排列；否则返回 nil。
+func detectLoop(hops []Hop) []string {
+	last := len(hops) - 1
+	if last < 0 || hops[last].Addr == "" {
+		return nil
+	}
+	// 找到同一地址上一次出现的位置，两者之间的距离就是环路的长度
+	k := 0
+	for j := last - 1; j >= 0; j-- {
+		if hops[j].Addr == hops[last].Addr {
+			k = last - j
+			break
+		}
+	}
+	if k == 0 || last-2*k < 0 {
+		return nil
+	}
+	for i := last - 2*k; i < last-k; i++ {
+		if hops[i].Addr == "" || hops[i].Addr != hops[i+k].Addr || hops[i].TTL+k != hops[i+k].TTL {
+			return nil
+		}
+	}
+	var members []string
+	for _, hop := range hops[last-k+1:] {
+		members = append(members, hop.Addr)
+	}
+	return members
+}
+
+// describeLoop 把环路成员格式化成 A → B → A 的形式
+func describeLoop(members []string) string {
+	addrs := anon.addrs(members)
+	return strings.Join(append(addrs, addrs[0]), " → ")
+}
+
+// reportLoop 打印检测到的环路
+func reportLoop(members []string) {
+	fmt.Printf("检测到路由环路: %s，探测在此停止\n", describeLoop(members))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	udpHeaderLen        = 8                             // UDP 头部长度
+	probeOverhead       = ipv4.HeaderLen + udpHeaderLen // 探测包除负载以外的长度
+	minMTU              = 68                            // IPv4 规定的最小MTU
+	defaultMTU          = 1500                          // 无法得知出接口MTU时使用的上限
+	maxPacketLen        = 65535                         // IPv4 包长度字段能表示的最大值
+	mtuAttempts         = 2                             // 每个长度最多尝试的次数，避免把偶发丢包误判为过大
+	codeFragNeeded      = 4                             // Destination Unreachable 中表示“需要分片”的代码
+	codePortUnreachable = 3                             // Destination Unreachable 中表示“端口不可达”的代码
+)
+
+// discoverMTUs 在路径探测完成之后，对每个有回应的跳用带 DF 标志、
+// 长度不同的探测包做二分查找，得出能够到达该跳的最大IP包长度，记在每一跳中。
+// 途中路由器发回的“需要分片”消息报告的下一跳 MTU 记在发出它的那一跳上；
+// 发出消息的路由器不在路径上（例如没有回应 TTL 超时的跳）时，消息原样返回。
+func discoverMTUs(p prober, result *TraceResult, destIP net.IP, maxMTU int) (offPath []*probeReply) {
+	// 路径MTU沿路只会减小不会增大，上一跳的结果就是这一跳的上限
+	upper := maxMTU
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		if hop.Addr == "" {
+			continue
+		}
+		var frags []*probeReply
+		hop.MTU, frags = hopMTU(p, hop.TTL, destIP, upper)
+		for _, f := range frags {
+			if f.NextHopMTU != 0 && !attributeNextMTU(result, f) {
+				offPath = append(offPath, f)
+			}
+		}
+		if hop.MTU > 0 {
+			upper = hop.MTU
+		}
+	}
+	return offPath
+}
+
+// attributeNextMTU 把“需要分片”消息报告的下一跳 MTU 记到发出它的那一跳上，
+// 同一跳只记第一次报告的值。发出消息的路由器不在路径上时返回 false。
+func attributeNextMTU(result *TraceResult, frag *probeReply) bool {
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		if hop.Addr != frag.Peer.String() {
+			continue
+		}
+		if hop.NextMTU == 0 {
+			hop.NextMTU = frag.NextHopMTU
+		}
+		return true
+	}
+	return false
+}
+
+// reportMTUs 打印逐跳的MTU表，MTU 变小的那一跳就是隧道等降低MTU的位置。
+// offPath 是 discoverMTUs 返回的、不在路径上的路由器发来的“需要分片”消息。
+func reportMTUs(result *TraceResult, offPath []*probeReply) {
+	fmt.Println("逐跳 MTU:")
+	prev := 0
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		if hop.Addr == "" {
+			continue
+		}
+		note := ""
+		if prev != 0 && hop.MTU < prev {
+			note = "  <- MTU 在此减小"
+		}
+		fmt.Printf("%2d %-15s %d%s\n", hop.TTL, anon.addr(hop.Addr), hop.MTU, note)
+		if hop.NextMTU != 0 {
+			fmt.Printf("    第 %d 跳 %s 报告需要分片，下一跳 MTU 为 %d\n", hop.TTL, anon.addr(hop.Addr), hop.NextMTU)
+		}
+		if hop.MTU > 0 {
+			prev = hop.MTU
+		}
+	}
+	for _, f := range offPath {
+		fmt.Printf("    %s 报告需要分片，下一跳 MTU 为 %d\n", anon.addr(f.Peer.String()), f.NextHopMTU)
+	}
+}
+
+// hopMTU 查找能够到达第 ttl 跳的最大IP包长度，找不到时返回 0，
+// 同时返回查找过程中收到的“需要分片”消息。
+// 路由器在消息中报告了下一跳 MTU 时，下一个探测包直接使用这个长度，
+// 通常一次就能确认，不必二分查找；没有报告（老设备）或报告的值不可信时才二分查找。
+func hopMTU(p prober, ttl int, destIP net.IP, upper int) (int, []*probeReply) {
+	var frags []*probeReply
+	ok, frag := reachesHop(p, ttl, destIP, upper)
+	if ok {
+		return upper, nil
+	}
+	lo, hi := probeOverhead, upper
+	for frag != nil {
+		frags = append(frags, frag)
+		size := frag.NextHopMTU
+		if size <= lo || size < minMTU || size >= hi {
+			break
+		}
+		if ok, frag = reachesHop(p, ttl, destIP, size); ok {
+			lo = size
+			break
+		}
+		hi = size
+	}
+	// 不带负载的最小探测包已经确认能到达这一跳
+	if lo == probeOverhead {
+		if ok, _ := reachesHop(p, ttl, destIP, lo); !ok {
+			return 0, frags
+		}
+	}
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		ok, frag := reachesHop(p, ttl, destIP, mid)
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+			if frag != nil {
+				frags = append(frags, frag)
+			}
+		}
+	}
+	return lo, frags
+}
+
+// reachesHop 判断长度为 size 的带 DF 探测包能否到达第 ttl 跳，
+// 因为包太大被途中路由器丢弃时同时返回它发回的“需要分片”消息
+func reachesHop(p prober, ttl int, destIP net.IP, size int) (bool, *probeReply) {
+	payload := make([]byte, size-probeOverhead)
+	for attempt := 0; attempt < mtuAttempts; attempt++ {
+		reply, err := p.probe(ttl, &net.UDPAddr{IP: destIP, Port: destPort}, payload)
+		if err != nil {
+			// 通常是超过了出接口MTU，本地直接返回 EMSGSIZE
+			return false, nil
+		}
+		if reply == nil {
+			continue
+		}
+		switch {
+		case reply.Type == ipv4.ICMPTypeTimeExceeded:
+			return true, nil
+		case reply.Type == ipv4.ICMPTypeDestinationUnreachable && reply.Code == codeFragNeeded:
+			// 途中某个路由器因为包太大而丢弃了它
+			return false, reply
+		case reply.Type == ipv4.ICMPTypeDestinationUnreachable:
+			// 到达了目标主机本身
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// interfaceMTU 返回出接口的MTU，作为二分查找的上限
+func interfaceMTU(egress *Egress) int {
+	if egress != nil && egress.Interface != "" {
+		if ifi, err := net.InterfaceByName(egress.Interface); err == nil && ifi.MTU >= minMTU {
+			// 回环接口的MTU可能是65536，超过了一个IPv4包的最大长度
+			return min(ifi.MTU, maxPacketLen)
+		}
+	}
+	return defaultMTU
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// natsDefaultPort 是 NATS 服务端的默认客户端端口
+const natsDefaultPort = "4222"
+
+// natsSink 把逐跳事件和最终结果以 JSON 发布到 NATS：
+// 每一跳探测完成时发布到 <subject>.hop，所有测量完成后把完整结果发布到 <subject>.result。
+// 只使用 NATS 的核心文本协议（CONNECT、PUB、PING），JetStream 中订阅了这些主题的
+// 流同样会把消息持久化下来，只是发布端不等待 JetStream 的确认。
+type natsSink struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	subject string
+	traceID string
+	filter  *hopFilter // 完整结果中只发布满足 --filter 的跳，逐跳事件由 emitHop 过滤
+	err     error      // 逐跳发布时遇到的第一个错误，在 complete 中返回
+}
+
+// natsHopEvent 是发布到 <subject>.hop 的消息
+type natsHopEvent struct {
+	TraceID string `json:"trace_id"`
+	Target  string `json:"target"`
+	Hop     *Hop   `json:"hop"`
+}
+
+// newNATSSink 连接 rawURL 指定的 NATS 服务端，形如 nats://[用户:密码@]host[:port]，
+// 只有用户名时作为 token 认证；tls:// 开头时使用 TLS 连接
+func newNATSSink(rawURL, subject string, traceID uint32, filter *hopFilter) (*natsSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 NATS 地址 %q: %w", rawURL, err)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("无效的 NATS 地址 %q，应以 nats:// 或 tls:// 开头", rawURL)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), natsDefaultPort)
+	}
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("连接 NATS %s 失败: %w", addr, err)
+	}
+	s := &natsSink{conn: conn, r: bufio.NewReader(conn), subject: strings.TrimSuffix(subject, "."), traceID: fmt.Sprintf("%08x", traceID), filter: filter}
+	if err := s.handshake(u); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("连接 NATS %s 失败: %w", addr, err)
+	}
+	return s, nil
+}
+
+// handshake 读取服务端的 INFO，需要时升级到 TLS，然后发送 CONNECT 并用 PING 确认认证成功
+func (s *natsSink) handshake(u *url.URL) error {
+	s.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer s.conn.SetDeadline(time.Time{})
+
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	body, ok := strings.CutPrefix(strings.TrimSpace(line), "INFO ")
+	if !ok {
+		return fmt.Errorf("服务端没有发送 INFO: %q", strings.TrimSpace(line))
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if err := json.Unmarshal([]byte(body), &info); err != nil {
+		return fmt.Errorf("无法解析服务端的 INFO: %w", err)
+	}
+	switch {
+	case u.Scheme == "tls":
+		tc := tls.Client(s.conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			return err
+		}
+		s.conn, s.r = tc, bufio.NewReader(tc)
+	case info.TLSRequired:
+		return fmt.Errorf("服务端要求 TLS，请使用 tls:// 地址")
+	}
+
+	connect := map[string]any{"verbose": false, "pedantic": false, "name": "udp-traceroute", "lang": "go", "version": "1"}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			connect["user"], connect["pass"] = u.User.Username(), pass
+		} else {
+			connect["auth_token"] = u.User.Username()
+		}
+	}
+	opts, _ := json.Marshal(connect)
+	if _, err := fmt.Fprintf(s.conn, "CONNECT %s\r\n", opts); err != nil {
+		return err
+	}
+	return s.ping()
+}
+
+// ping 发送 PING 并等待 PONG，服务端在此之前处理完了前面所有的消息，
+// 期间返回的 -ERR（例如认证失败、没有发布权限）作为错误返回
+func (s *natsSink) ping() error {
+	if _, err := s.conn.Write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			s.conn.Write([]byte("PONG\r\n"))
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("服务端返回错误: %s", strings.Trim(strings.TrimPrefix(line, "-ERR"), " '"))
+		}
+		// 其余的 INFO、+OK 不影响发布，跳过
+	}
+}
+
+// publish 把 v 序列化成 JSON 发布到 subject
+func (s *natsSink) publish(subject string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("序列化结果失败: %w", err)
+	}
+	msg := fmt.Appendf(nil, "PUB %s %d\r\n", subject, len(body))
+	msg = append(append(msg, body...), "\r\n"...)
+	_, err = s.conn.Write(msg)
+	return err
+}
+
+func (s *natsSink) start(target, destIP string, egress *Egress) {}
+
+func (s *natsSink) hop(target string, hop *Hop) {
+	if s.err != nil {
+		return
+	}
+	s.err = s.publish(s.subject+".hop", natsHopEvent{TraceID: s.traceID, Target: anon.addr(target), Hop: anon.hop(hop)})
+}
+
+func (s *natsSink) complete(result *TraceResult) error {
+	if s.err != nil {
+		return fmt.Errorf("发布到 NATS 失败: %w", s.err)
+	}
+	if err := s.publish(s.subject+".result", anon.result(s.filter.result(result))); err != nil {
+		return fmt.Errorf("发布到 NATS 失败: %w", err)
+	}
+	s.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := s.ping(); err != nil {
+		return fmt.Errorf("发布到 NATS 失败: %w", err)
+	}
+	return nil
+}
+
+func (s *natsSink) Close() error { return s.conn.Close() }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// ouiTable 把 OUI（六个小写十六进制数字）映射到厂商名
+type ouiTable map[string]string
+
+// builtinOUIs 是内置的一小部分厂商 OUI（MAC 地址的前三个字节），
+// 覆盖常见的网络设备和虚拟化平台；需要完整的对照表时用 --oui-file 加载 IEEE 的 oui.csv
+var builtinOUIs = ouiTable{
+	"00000c": "Cisco",
+	"000585": "Juniper Networks",
+	"001c73": "Arista Networks",
+	"00e0fc": "Huawei",
+	"000fe2": "H3C",
+	"000c42": "MikroTik",
+	"4c5e0c": "MikroTik",
+	"000b86": "Aruba Networks",
+	"00090f": "Fortinet",
+	"001b17": "Palo Alto Networks",
+	"000db9": "PC Engines",
+	"001132": "Synology",
+	"005056": "VMware",
+	"000c29": "VMware",
+	"000569": "VMware",
+	"080027": "VirtualBox",
+	"00155d": "Microsoft Hyper-V",
+	"001c42": "Parallels",
+	"00163e": "Xen",
+}
+
+// loadOUIs 读取 IEEE 发布的 oui.csv（列为 Registry,Assignment,Organization Name,...），
+// 和内置的对照表合并，文件中的条目优先
+func loadOUIs(path string) (ouiTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	t := ouiTable{}
+	for oui, vendor := range builtinOUIs {
+		t[oui] = vendor
+	}
+	n := 0
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s 不是有效的 CSV 文件: %w", path, err)
+		}
+		if len(record) < 3 || len(record[1]) != 6 {
+			continue // 表头或 MA-M、MA-S 等更长的分配
+		}
+		t[strings.ToLower(record[1])] = strings.TrimSpace(record[2])
+		n++
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%s 中没有任何 OUI 条目", path)
+	}
+	return t, nil
+}
+
+// vendor 返回 MAC 地址对应的说明。VRRP、HSRP 和 GLBP 的虚拟 MAC 单独识别：
+// 多台路由器共用一个网关地址时，它说明探测包发给的是备份组而不是某一台固定的设备，
+// 实际转发的是当前的主路由器。本地管理的地址多半来自虚拟机、容器或随机化的 MAC。
+func (t ouiTable) vendor(mac net.HardwareAddr) string {
+	if len(mac) != 6 {
+		return ""
+	}
+	switch {
+	case mac[0] == 0x00 && mac[1] == 0x00 && mac[2] == 0x5e && mac[3] == 0x00 && mac[4] == 0x01:
+		return fmt.Sprintf("VRRP 虚拟 MAC，VRID %d", mac[5])
+	case mac[0] == 0x00 && mac[1] == 0x00 && mac[2] == 0x0c && mac[3] == 0x07 && mac[4] == 0xac:
+		return fmt.Sprintf("HSRPv1 虚拟 MAC，组 %d", mac[5])
+	case mac[0] == 0x00 && mac[1] == 0x00 && mac[2] == 0x0c && mac[3] == 0x9f && mac[4]&0xf0 == 0xf0:
+		return fmt.Sprintf("HSRPv2 虚拟 MAC，组 %d", int(mac[4]&0x0f)<<8|int(mac[5]))
+	case mac[0] == 0x00 && mac[1] == 0x07 && mac[2] == 0xb4 && mac[3]&0xfc == 0:
+		return fmt.Sprintf("GLBP 虚拟 MAC，组 %d 转发者 %d", int(mac[3]&0x03)<<8|int(mac[4]), mac[5])
+	}
+	if t == nil {
+		t = builtinOUIs
+	}
+	if v, ok := t[fmt.Sprintf("%02x%02x%02x", mac[0], mac[1], mac[2])]; ok {
+		return v
+	}
+	if mac[0]&0x02 != 0 {
+		return "本地管理地址"
+	}
+	return ""
+}
+
+// resolveGateway 在路径探测之后查询网关的 MAC 地址：
+// 第一跳的探测包已经让内核解析过网关，这时邻居表中一定有它的条目
+func resolveGateway(egress *Egress, ouis ouiTable) error {
+	switch {
+	case egress == nil:
+		return fmt.Errorf("没有出口路由信息")
+	case egress.Gateway == "":
+		return fmt.Errorf("目标直连，没有网关")
+	}
+	mac, err := lookupNeighbor(net.ParseIP(egress.Gateway), egress.Interface)
+	if err != nil {
+		return err
+	}
+	egress.GatewayMAC = mac.String()
+	egress.GatewayVendor = ouis.vendor(mac)
+	return nil
+}
+
+// reportGateway 打印网关的 MAC 地址和厂商，并核对第一跳是否就是网关：
+// 网关用另一个接口地址回应 Time Exceeded，或者第一跳根本不回应时，
+// MAC 地址是确认探测包实际经过哪台设备的唯一线索
+func reportGateway(result *TraceResult) {
+	e := result.Egress
+	if e == nil || e.GatewayMAC == "" {
+		return
+	}
+	line := fmt.Sprintf("网关 %s 的 MAC 地址 %s", anon.addr(e.Gateway), anon.mac(e.GatewayMAC))
+	if e.GatewayVendor != "" {
+		line += "（" + e.GatewayVendor + "）"
+	}
+	if len(result.Hops) > 0 && result.Hops[0].TTL == 1 {
+		switch first := &result.Hops[0]; {
+		case first.Timeout:
+			line += "，第 1 跳没有回应"
+		case first.Addr != e.Gateway:
+			line += fmt.Sprintf("，第 1 跳从另一个地址 %s 回应", anon.addr(first.Addr))
+		}
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lookupNeighbor 在 /proc/net/arp（当前网络命名空间的 ARP 表）中查找 ip 在接口 iface 上的 MAC 地址。
+// 标志为 0 的条目还没有解析完成，不算找到。
+func lookupNeighbor(ip net.IP, iface string) (net.HardwareAddr, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, fmt.Errorf("读取 ARP 表失败: %w", err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Scan() // 表头：IP address, HW type, Flags, HW address, Mask, Device
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 || !net.ParseIP(fields[0]).Equal(ip) || (iface != "" && fields[5] != iface) {
+			continue
+		}
+		if flags, err := strconv.ParseUint(fields[2], 0, 32); err != nil || flags == 0 {
+			continue
+		}
+		return net.ParseMAC(fields[3])
+	}
+	return nil, fmt.Errorf("ARP 表中没有 %s 的条目", ip)
+}
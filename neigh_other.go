@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// lookupNeighbor 在没有 /proc/net/arp 的平台上无法查询邻居表
+func lookupNeighbor(ip net.IP, iface string) (net.HardwareAddr, error) {
+	return nil, fmt.Errorf("当前平台不支持查询 %s 的 MAC 地址", ip)
+}
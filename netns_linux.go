@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// netnsDir 是 `ip netns add` 创建的具名网络命名空间所在的目录
+const netnsDir = "/run/netns/"
+
+// enterNetns 把当前 goroutine 所在的线程切换到指定的网络命名空间。
+// spec 可以是 `ip netns` 中的名字，也可以是 /proc/PID/ns/net 这样的路径。
+//
+// 网络命名空间是线程级别的属性，而套接字在创建时就归属于当时线程
+// 所在的命名空间，所以这里会把调用者永久锁定在当前线程上。
+// 之后在同一个 goroutine 中创建的探测套接字都位于目标命名空间内；
+// 域名解析和 webhook 等在其他 goroutine 中进行的网络操作仍然使用原来的命名空间。
+func enterNetns(spec string) error {
+	path := spec
+	if !strings.Contains(spec, "/") {
+		path = netnsDir + spec
+	}
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("打开网络命名空间 %s 失败: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	// 故意不调用 UnlockOSThread：线程已经被修改，不能再交还给调度器
+	runtime.LockOSThread()
+	if err := unix.Setns(fd, unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("切换到网络命名空间 %s 失败: %w", path, err)
+	}
+	return nil
+}
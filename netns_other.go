@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// enterNetns 只在 Linux 上可用
+func enterNetns(spec string) error {
+	return errors.New("--netns 仅在 Linux 上可用")
+}
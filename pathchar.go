@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+const (
+	pathcharSizes = 8    // 每一跳使用的不同包长数量
+	pathcharMin   = 64   // 最小的探测包长度
+	z95           = 1.96 // 95% 置信区间对应的正态分位数
+)
+
+// LinkEstimate 是 pathchar 风格测量得到的“上一跳到这一跳”这段链路的估计值
+type LinkEstimate struct {
+	BandwidthBps     float64 `json:"bandwidth_bps"`                // 带宽估计，单位 bit/s
+	BandwidthLowBps  float64 `json:"bandwidth_low_bps"`            // 95% 置信区间下限
+	BandwidthHighBps float64 `json:"bandwidth_high_bps,omitempty"` // 95% 置信区间上限，0 表示无上限
+	LatencyMs        float64 `json:"latency_ms"`                   // 链路往返固定时延的估计（不含排队和串行化）
+}
+
+// lineFit 是最小RTT关于包长的线性回归结果：rtt = intercept + slope * size
+type lineFit struct {
+	intercept, slope     float64 // 单位分别为 秒 和 秒/字节
+	seIntercept, seSlope float64 // 对应的标准误差
+}
+
+// estimateLinks 对每个有回应的跳发送若干组不同长度的探测包，
+// 取每个长度的最小RTT（排除排队时延）并对包长做线性回归。
+// 斜率表示到达这一跳为止每个字节的串行化时间，相邻两跳斜率之差的倒数
+// 就是这两跳之间链路的带宽；截距之差则是这段链路的固定时延。
+// 这种方法对噪声很敏感，因此同时给出置信区间，结果仅供参考。
+func estimateLinks(p prober, result *TraceResult, destIP net.IP, maxSize, reps int) {
+	// 第一段链路的“上一跳”是本机，斜率和截距都视为0
+	prev := lineFit{}
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		if hop.Addr == "" {
+			continue
+		}
+		limit := maxSize
+		if hop.MTU > 0 {
+			limit = hop.MTU
+		}
+		fit, ok := fitHop(p, hop.TTL, destIP, limit, reps)
+		if !ok {
+			continue
+		}
+		hop.Link = linkBetween(prev, fit)
+		prev = fit
+	}
+}
+
+// reportLinks 打印 estimateLinks 得到的逐链路估计，没有估计值的跳说明样本不足
+func reportLinks(result *TraceResult) {
+	fmt.Println("逐链路带宽估计（实验性）:")
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		switch {
+		case hop.Addr == "":
+		case hop.Link == nil:
+			fmt.Printf("%2d %-15s 样本不足，无法估计\n", hop.TTL, anon.addr(hop.Addr))
+		default:
+			fmt.Printf("%2d %-15s %s\n", hop.TTL, anon.addr(hop.Addr), describeLink(hop.Link))
+		}
+	}
+}
+
+// fitHop 测量第 ttl 跳各个包长的最小RTT并做线性回归
+func fitHop(p prober, ttl int, destIP net.IP, maxSize, reps int) (lineFit, bool) {
+	var xs, ys []float64
+	for i := 0; i < pathcharSizes; i++ {
+		size := pathcharMin + (maxSize-pathcharMin)*i/(pathcharSizes-1)
+		payload := make([]byte, size-probeOverhead)
+		best := time.Duration(0)
+		for r := 0; r < reps; r++ {
+			reply, err := p.probe(ttl, &net.UDPAddr{IP: destIP, Port: destPort}, payload)
+			if err != nil || reply == nil {
+				continue
+			}
+			if best == 0 || reply.RTT < best {
+				best = reply.RTT
+			}
+		}
+		if best > 0 {
+			xs = append(xs, float64(size))
+			ys = append(ys, best.Seconds())
+		}
+	}
+	if len(xs) < 3 {
+		return lineFit{}, false
+	}
+	return fitLine(xs, ys), true
+}
+
+// fitLine 最小二乘线性回归，并计算斜率和截距的标准误差
+func fitLine(xs, ys []float64) lineFit {
+	n := float64(len(xs))
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= n
+	my /= n
+	var sxx, sxy float64
+	for i := range xs {
+		sxx += (xs[i] - mx) * (xs[i] - mx)
+		sxy += (xs[i] - mx) * (ys[i] - my)
+	}
+	fit := lineFit{slope: sxy / sxx}
+	fit.intercept = my - fit.slope*mx
+
+	var sse float64
+	for i := range xs {
+		r := ys[i] - fit.intercept - fit.slope*xs[i]
+		sse += r * r
+	}
+	s2 := sse / (n - 2)
+	fit.seSlope = math.Sqrt(s2 / sxx)
+	fit.seIntercept = math.Sqrt(s2 * (1/n + mx*mx/sxx))
+	return fit
+}
+
+// linkBetween 根据相邻两跳的回归结果推算它们之间链路的带宽和时延
+func linkBetween(prev, cur lineFit) *LinkEstimate {
+	dSlope := cur.slope - prev.slope
+	se := math.Hypot(cur.seSlope, prev.seSlope)
+	link := &LinkEstimate{
+		LatencyMs: (cur.intercept - prev.intercept) * 1000,
+	}
+	// 斜率单位是 秒/字节，换算成 bit/s 需要乘以 8
+	if dSlope > 0 {
+		link.BandwidthBps = 8 / dSlope
+	}
+	if hi := dSlope + z95*se; hi > 0 {
+		link.BandwidthLowBps = 8 / hi
+	}
+	if lo := dSlope - z95*se; lo > 0 {
+		link.BandwidthHighBps = 8 / lo
+	}
+	return link
+}
+
+// describeLink 把链路估计格式化成一行说明
+func describeLink(l *LinkEstimate) string {
+	if l.BandwidthBps == 0 {
+		return fmt.Sprintf("带宽无法分辨（斜率未增加），时延 %.2f ms", l.LatencyMs)
+	}
+	high := "∞"
+	if l.BandwidthHighBps > 0 {
+		high = formatBandwidth(l.BandwidthHighBps)
+	}
+	return fmt.Sprintf("带宽 %s (95%% 置信区间 %s – %s)，时延 %.2f ms",
+		formatBandwidth(l.BandwidthBps), formatBandwidth(l.BandwidthLowBps), high, l.LatencyMs)
+}
+
+// formatBandwidth 用合适的单位显示带宽
+func formatBandwidth(bps float64) string {
+	switch {
+	case bps >= 1e9:
+		return fmt.Sprintf("%.1f Gbit/s", bps/1e9)
+	case bps >= 1e6:
+		return fmt.Sprintf("%.1f Mbit/s", bps/1e6)
+	case bps >= 1e3:
+		return fmt.Sprintf("%.1f Kbit/s", bps/1e3)
+	default:
+		return fmt.Sprintf("%.0f bit/s", bps)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// findPathLength 用二分查找估计到达目标需要的跳数：
+// 对某个TTL，如果目标已经回应“不可达”，说明路径长度不超过它，否则路径更长。
+// 这样最多只需 log2(maxHops)+1 次探测就能得到跳数，而不必从第一跳开始逐跳探测。
+// 返回的 probes 是实际发送的探测包数量；到 maxHops 仍未到达时 ok 为 false。
+func findPathLength(p prober, destIP net.IP) (length, probes int, ok bool) {
+	reaches := func(ttl int) bool {
+		// 超时既可能是这一跳不回应，也可能是目标在限制ICMP的发送速率
+		// （二分查找会连续触发好几个端口不可达），等一会儿重试一次再下结论。
+		// 两次都超时的跳当作尚未到达目标，最坏情况下只是多探测几跳。
+		for i := range 2 {
+			if i > 0 {
+				time.Sleep(backoffMax)
+			}
+			probes++
+			reply, err := p.probe(ttl, &net.UDPAddr{IP: destIP, Port: destPort}, nil)
+			if err != nil || reply == nil {
+				continue
+			}
+			return reply.Type == ipv4.ICMPTypeDestinationUnreachable
+		}
+		return false
+	}
+
+	if !reaches(maxHops) {
+		return maxHops, probes, false
+	}
+	// 不变式：lo 跳到不了目标，hi 跳可以
+	lo, hi := 0, maxHops
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if reaches(mid) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi, probes, true
+}
+
+// bisectFirstTTL 估计路径长度并返回只探测最后 last 跳时的起始TTL，
+// 以及路径长度（没有到达目标时为 0）和二分查找发送的探测包数量
+func bisectFirstTTL(p prober, destIP net.IP, last int) (first, length, probes int) {
+	length, probes, ok := findPathLength(p, destIP)
+	if !ok {
+		return 1, 0, probes
+	}
+	// 让目标的ICMP限速恢复过来，否则逐跳探测时最后一跳可能被误判为超时
+	time.Sleep(backoffMax)
+	return max(length-last+1, 1), length, probes
+}
+
+// reportBisect 打印二分查找的结果
+func reportBisect(length, probes int) {
+	if length == 0 {
+		fmt.Printf("二分查找：%d 跳内没有到达目标（探测 %d 次），从第 1 跳开始逐跳探测\n", maxHops, probes)
+		return
+	}
+	fmt.Printf("二分查找：路径长度约为 %d 跳（探测 %d 次）\n", length, probes)
+}
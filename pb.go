@@ -0,0 +1,755 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// outputPB 把完整结果编码成 result.proto 中的 TraceResult 消息，
+// 比 JSON 紧凑，适合探测点和收集端之间交换结果
+const outputPB = "pb"
+
+// 这里没有引入 protobuf 库，也不需要 protoc 生成代码，而是按 result.proto 手写了编解码，
+// 只用到 protobuf 线格式中的 varint、64 位定长和按长度分隔三种类型。
+// 修改 result.go 中的结构时要同时修改 result.proto 和这里，pb_test.go 会检查每个字段都能往返。
+// 手写的只是消息编码；gRPC 的服务桩和传输仍然需要 google.golang.org/grpc，
+// 所以探测点之间交换结果用的是 peer 子命令的 HTTP 接口。
+
+// protobuf 线格式的类型
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// pbBuf 是正在编码的消息。按 proto3 的规则，取零值的标量字段不写出。
+type pbBuf []byte
+
+func (b *pbBuf) tag(field, wire int) { *b = binary.AppendUvarint(*b, uint64(field)<<3|uint64(wire)) }
+
+func (b *pbBuf) int(field int, v int64) {
+	if v != 0 {
+		b.tag(field, wireVarint)
+		*b = binary.AppendUvarint(*b, uint64(v))
+	}
+}
+
+func (b *pbBuf) bool(field int, v bool) {
+	if v {
+		b.int(field, 1)
+	}
+}
+
+func (b *pbBuf) double(field int, v float64) {
+	if v != 0 {
+		b.tag(field, wireFixed64)
+		*b = binary.LittleEndian.AppendUint64(*b, math.Float64bits(v))
+	}
+}
+
+func (b *pbBuf) bytes(field int, v []byte) {
+	b.tag(field, wireBytes)
+	*b = binary.AppendUvarint(*b, uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *pbBuf) string(field int, v string) {
+	if v != "" {
+		b.bytes(field, []byte(v))
+	}
+}
+
+// strings 写出 repeated string，空字符串也要写出以保持元素的位置
+func (b *pbBuf) strings(field int, list []string) {
+	for _, s := range list {
+		b.bytes(field, []byte(s))
+	}
+}
+
+// doubles 按 proto3 的默认方式把 repeated double 打包成一个字段
+func (b *pbBuf) doubles(field int, list []float64) {
+	if len(list) == 0 {
+		return
+	}
+	var packed []byte
+	for _, v := range list {
+		packed = binary.LittleEndian.AppendUint64(packed, math.Float64bits(v))
+	}
+	b.bytes(field, packed)
+}
+
+// ints 按 proto3 的默认方式把 repeated int64 打包成一个字段
+func (b *pbBuf) ints(field int, list []int) {
+	if len(list) == 0 {
+		return
+	}
+	var packed []byte
+	for _, v := range list {
+		packed = binary.AppendUvarint(packed, uint64(int64(v)))
+	}
+	b.bytes(field, packed)
+}
+
+// message 写出一个嵌套消息，即使它的所有字段都是零值
+func (b *pbBuf) message(field int, encode func(m *pbBuf)) {
+	var m pbBuf
+	encode(&m)
+	b.bytes(field, m)
+}
+
+// time 按 google.protobuf.Timestamp 的格式写出时间
+func (b *pbBuf) time(field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	b.message(field, func(m *pbBuf) {
+		m.int(1, t.Unix())
+		m.int(2, int64(t.Nanosecond()))
+	})
+}
+
+// encodeResult 把结果编码成 TraceResult 消息
+func encodeResult(b *pbBuf, r *TraceResult) {
+	b.int(1, int64(r.SchemaVersion))
+	b.string(2, r.TraceID)
+	b.string(3, r.Target)
+	b.string(4, r.DestIP)
+	if e := r.Egress; e != nil {
+		b.message(5, func(m *pbBuf) {
+			m.string(1, e.Source)
+			m.string(2, e.Interface)
+			m.string(3, e.Gateway)
+			m.string(4, e.GatewayMAC)
+			m.string(5, e.GatewayVendor)
+		})
+	}
+	b.bool(6, r.Reached)
+	b.time(7, r.StartedAt)
+	for i := range r.Hops {
+		b.message(8, func(m *pbBuf) { encodeHop(m, &r.Hops[i]) })
+	}
+	b.int(9, int64(r.PathLength))
+	if r.Reverse != nil {
+		b.message(10, func(m *pbBuf) { encodeResult(m, r.Reverse) })
+	}
+	b.strings(11, r.Loop)
+	for _, ev := range r.LateReplies {
+		b.message(12, func(m *pbBuf) {
+			m.string(1, ev.Kind)
+			m.int(2, int64(ev.TTL))
+			m.string(3, ev.Addr)
+			m.double(4, ev.RTTMs)
+		})
+	}
+	// map 的每一项是一个 key=1、value=2 的消息，按键排序让同样的结果编码出同样的字节
+	reasons := make([]string, 0, len(r.Rejected))
+	for reason := range r.Rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		b.message(13, func(m *pbBuf) {
+			m.string(1, reason)
+			m.int(2, int64(r.Rejected[reason]))
+		})
+	}
+	for _, e := range r.Errors {
+		b.message(14, func(m *pbBuf) {
+			m.string(1, e.Code)
+			m.string(2, e.Message)
+			m.int(3, int64(e.TTL))
+			m.bool(4, e.Retryable)
+		})
+	}
+	if c := r.Connect; c != nil {
+		b.message(15, func(m *pbBuf) {
+			m.int(1, int64(c.Port))
+			m.int(2, int64(c.Attempts))
+			m.int(3, int64(c.Succeeded))
+			m.double(4, c.RTTMs)
+			m.doubles(5, c.RTTsMs)
+			m.string(6, c.Error)
+		})
+	}
+	if s := r.DestPing; s != nil {
+		b.message(16, func(m *pbBuf) {
+			m.double(1, s.IntervalMs)
+			m.int(2, int64(s.Sent))
+			m.int(3, int64(s.Received))
+			m.double(4, s.LossPct)
+			m.double(5, s.RTTMinMs)
+			m.double(6, s.RTTAvgMs)
+			m.double(7, s.RTTMaxMs)
+			m.doubles(8, s.RTTsMs)
+			m.int(9, int64(s.Rejected))
+		})
+	}
+	b.string(17, r.RTTStat)
+	b.string(18, r.TargetASCII)
+}
+
+func encodeHop(b *pbBuf, h *Hop) {
+	b.int(1, int64(h.TTL))
+	b.string(2, h.Addr)
+	b.string(3, h.Host)
+	b.string(4, h.Class)
+	b.string(5, h.IXP)
+	if p := h.IPAM; p != nil {
+		b.message(6, func(m *pbBuf) {
+			m.string(1, p.Label)
+			m.string(2, p.Site)
+			m.string(3, p.Owner)
+		})
+	}
+	b.bool(7, h.PTRMismatch)
+	b.double(8, h.RTTMs)
+	b.string(9, h.State)
+	b.int(10, int64(h.ICMPType))
+	b.int(11, int64(h.ICMPCode))
+	b.bool(12, h.Timeout)
+	b.int(13, int64(h.MTU))
+	b.strings(14, h.Labels)
+	b.string(15, h.Protocol)
+	b.int(16, int64(h.ASN))
+	b.bool(17, h.ASBoundary)
+	b.string(18, h.Prefix)
+	b.string(19, h.RPKI)
+	b.int(20, int64(h.Sent))
+	b.int(21, int64(h.Received))
+	b.double(22, h.LossPct)
+	b.doubles(23, h.RTTsMs)
+	b.bool(24, h.RateLimited)
+	b.int(25, int64(h.Late))
+	b.int(26, int64(h.Duplicates))
+	for _, p := range h.Probes {
+		b.message(27, func(m *pbBuf) {
+			m.int(1, int64(p.Seq))
+			m.string(2, p.Addr)
+			m.double(3, p.RTTMs)
+			m.int(4, int64(p.ICMPType))
+			m.int(5, int64(p.ICMPCode))
+			m.bool(6, p.Timeout)
+			m.time(7, p.SentAt)
+			m.time(8, p.ReceivedAt)
+			m.int(9, p.RTTNs)
+			m.string(10, p.Iface)
+			m.int(11, int64(p.Port))
+			m.int(12, int64(p.ReplyTTL))
+			m.int(13, int64(p.QuotedTTL))
+		})
+	}
+	b.string(28, h.LoadBalancing)
+	b.strings(29, h.Responders)
+	if l := h.Link; l != nil {
+		b.message(30, func(m *pbBuf) {
+			m.double(1, l.BandwidthBps)
+			m.double(2, l.BandwidthLowBps)
+			m.double(3, l.BandwidthHighBps)
+			m.double(4, l.LatencyMs)
+		})
+	}
+	b.ints(31, h.Ports)
+	if p := h.Ping; p != nil {
+		b.message(32, func(m *pbBuf) {
+			m.int(1, int64(p.Sent))
+			m.int(2, int64(p.Received))
+			m.double(3, p.RTTMs)
+			m.doubles(4, p.RTTsMs)
+			m.int(5, int64(p.Rejected))
+			m.int(6, int64(p.ReplyTTL))
+		})
+	}
+	b.int(33, int64(h.ReplyTTL))
+	b.int(34, int64(h.QuotedTTL))
+	b.int(35, int64(h.HiddenHops))
+	for _, l := range h.MPLS {
+		b.message(36, func(m *pbBuf) {
+			m.int(1, int64(l.Label))
+			m.int(2, int64(l.TC))
+			m.bool(3, l.S)
+			m.int(4, int64(l.TTL))
+		})
+	}
+	b.string(37, h.Tunnel)
+	b.int(38, int64(h.NextMTU))
+	b.double(40, h.RTTAvgMs)
+	b.int(41, int64(h.RTTDropped))
+	if g := h.Geo; g != nil {
+		b.message(39, func(m *pbBuf) {
+			m.double(1, g.Lat)
+			m.double(2, g.Lon)
+			m.string(3, g.Label)
+			m.double(4, g.DistanceKm)
+			m.double(5, g.MinRTTMs)
+			m.bool(6, g.Impossible)
+			m.double(7, g.RadiusKm)
+		})
+	}
+}
+
+// pbField 是解码出的一个字段，v 保存 varint 和定长类型的值，data 保存按长度分隔类型的内容
+type pbField struct {
+	num  int
+	wire int
+	v    uint64
+	data []byte
+}
+
+func (f pbField) int() int        { return int(int64(f.v)) }
+func (f pbField) bool() bool      { return f.v != 0 }
+func (f pbField) str() string     { return string(f.data) }
+func (f pbField) double() float64 { return math.Float64frombits(f.v) }
+
+// doubles 把 repeated double 的一个字段追加到 list 中，
+// 读取方要同时接受打包和未打包的编码
+func (f pbField) doubles(list []float64) ([]float64, error) {
+	if f.wire != wireBytes {
+		return append(list, f.double()), nil
+	}
+	if len(f.data)%8 != 0 {
+		return list, errPBTruncated
+	}
+	for i := 0; i < len(f.data); i += 8 {
+		list = append(list, math.Float64frombits(binary.LittleEndian.Uint64(f.data[i:])))
+	}
+	return list, nil
+}
+
+func (f pbField) time() (time.Time, error) {
+	var sec, nsec int64
+	err := pbFields(f.data, func(g pbField) error {
+		switch g.num {
+		case 1:
+			sec = int64(g.v)
+		case 2:
+			nsec = int64(g.v)
+		}
+		return nil
+	})
+	return time.Unix(sec, nsec), err
+}
+
+var errPBTruncated = errors.New("protobuf 数据不完整")
+
+// pbFields 依次解码消息中的字段交给 fn，不认识的字段由 fn 忽略即可。
+// 数据被截断或者不是 protobuf 编码时返回错误。
+func pbFields(data []byte, fn func(f pbField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errPBTruncated
+		}
+		data = data[n:]
+		f := pbField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			if f.v, n = binary.Uvarint(data); n <= 0 {
+				return errPBTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errPBTruncated
+			}
+			f.v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errPBTruncated
+			}
+			f.v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errPBTruncated
+			}
+			f.data, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			return fmt.Errorf("不支持的 protobuf 线格式类型 %d", f.wire)
+		}
+		if f.num == 0 {
+			return fmt.Errorf("无效的 protobuf 字段编号 0")
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeResult 解码 TraceResult 消息
+func decodeResult(data []byte, r *TraceResult) error {
+	return pbFields(data, func(f pbField) error {
+		var err error
+		switch f.num {
+		case 1:
+			r.SchemaVersion = f.int()
+		case 2:
+			r.TraceID = f.str()
+		case 3:
+			r.Target = f.str()
+		case 4:
+			r.DestIP = f.str()
+		case 5:
+			r.Egress = &Egress{}
+			err = pbFields(f.data, func(g pbField) error {
+				switch g.num {
+				case 1:
+					r.Egress.Source = g.str()
+				case 2:
+					r.Egress.Interface = g.str()
+				case 3:
+					r.Egress.Gateway = g.str()
+				case 4:
+					r.Egress.GatewayMAC = g.str()
+				case 5:
+					r.Egress.GatewayVendor = g.str()
+				}
+				return nil
+			})
+		case 6:
+			r.Reached = f.bool()
+		case 7:
+			r.StartedAt, err = f.time()
+		case 8:
+			var h Hop
+			err = decodeHop(f.data, &h)
+			r.Hops = append(r.Hops, h)
+		case 9:
+			r.PathLength = f.int()
+		case 10:
+			r.Reverse = &TraceResult{}
+			err = decodeResult(f.data, r.Reverse)
+		case 11:
+			r.Loop = append(r.Loop, f.str())
+		case 12:
+			var ev ReplyEvent
+			err = pbFields(f.data, func(g pbField) error {
+				switch g.num {
+				case 1:
+					ev.Kind = g.str()
+				case 2:
+					ev.TTL = g.int()
+				case 3:
+					ev.Addr = g.str()
+				case 4:
+					ev.RTTMs = g.double()
+				}
+				return nil
+			})
+			r.LateReplies = append(r.LateReplies, ev)
+		case 13:
+			var reason string
+			var count int
+			err = pbFields(f.data, func(g pbField) error {
+				switch g.num {
+				case 1:
+					reason = g.str()
+				case 2:
+					count = g.int()
+				}
+				return nil
+			})
+			if r.Rejected == nil {
+				r.Rejected = map[string]int{}
+			}
+			r.Rejected[reason] = count
+		case 14:
+			var e TraceError
+			err = pbFields(f.data, func(g pbField) error {
+				switch g.num {
+				case 1:
+					e.Code = g.str()
+				case 2:
+					e.Message = g.str()
+				case 3:
+					e.TTL = g.int()
+				case 4:
+					e.Retryable = g.bool()
+				}
+				return nil
+			})
+			r.Errors = append(r.Errors, e)
+		case 15:
+			r.Connect = &ConnectTiming{}
+			err = pbFields(f.data, func(g pbField) error {
+				var err error
+				switch g.num {
+				case 1:
+					r.Connect.Port = g.int()
+				case 2:
+					r.Connect.Attempts = g.int()
+				case 3:
+					r.Connect.Succeeded = g.int()
+				case 4:
+					r.Connect.RTTMs = g.double()
+				case 5:
+					r.Connect.RTTsMs, err = g.doubles(r.Connect.RTTsMs)
+				case 6:
+					r.Connect.Error = g.str()
+				}
+				return err
+			})
+		case 16:
+			s := &DestPing{}
+			r.DestPing = s
+			err = pbFields(f.data, func(g pbField) error {
+				var err error
+				switch g.num {
+				case 1:
+					s.IntervalMs = g.double()
+				case 2:
+					s.Sent = g.int()
+				case 3:
+					s.Received = g.int()
+				case 4:
+					s.LossPct = g.double()
+				case 5:
+					s.RTTMinMs = g.double()
+				case 6:
+					s.RTTAvgMs = g.double()
+				case 7:
+					s.RTTMaxMs = g.double()
+				case 8:
+					s.RTTsMs, err = g.doubles(s.RTTsMs)
+				case 9:
+					s.Rejected = g.int()
+				}
+				return err
+			})
+		case 17:
+			r.RTTStat = f.str()
+		case 18:
+			r.TargetASCII = f.str()
+		}
+		return err
+	})
+}
+
+func decodeHop(data []byte, h *Hop) error {
+	return pbFields(data, func(f pbField) error {
+		var err error
+		switch f.num {
+		case 1:
+			h.TTL = f.int()
+		case 2:
+			h.Addr = f.str()
+		case 3:
+			h.Host = f.str()
+		case 4:
+			h.Class = f.str()
+		case 5:
+			h.IXP = f.str()
+		case 6:
+			h.IPAM = &IPAMInfo{}
+			err = pbFields(f.data, func(g pbField) error {
+				switch g.num {
+				case 1:
+					h.IPAM.Label = g.str()
+				case 2:
+					h.IPAM.Site = g.str()
+				case 3:
+					h.IPAM.Owner = g.str()
+				}
+				return nil
+			})
+		case 7:
+			h.PTRMismatch = f.bool()
+		case 8:
+			h.RTTMs = f.double()
+		case 9:
+			h.State = f.str()
+		case 10:
+			h.ICMPType = f.int()
+		case 11:
+			h.ICMPCode = f.int()
+		case 12:
+			h.Timeout = f.bool()
+		case 13:
+			h.MTU = f.int()
+		case 14:
+			h.Labels = append(h.Labels, f.str())
+		case 15:
+			h.Protocol = f.str()
+		case 16:
+			h.ASN = f.int()
+		case 17:
+			h.ASBoundary = f.bool()
+		case 18:
+			h.Prefix = f.str()
+		case 19:
+			h.RPKI = f.str()
+		case 20:
+			h.Sent = f.int()
+		case 21:
+			h.Received = f.int()
+		case 22:
+			h.LossPct = f.double()
+		case 23:
+			h.RTTsMs, err = f.doubles(h.RTTsMs)
+		case 24:
+			h.RateLimited = f.bool()
+		case 25:
+			h.Late = f.int()
+		case 26:
+			h.Duplicates = f.int()
+		case 27:
+			var p ProbeRecord
+			err = pbFields(f.data, func(g pbField) error {
+				var err error
+				switch g.num {
+				case 1:
+					p.Seq = g.int()
+				case 2:
+					p.Addr = g.str()
+				case 3:
+					p.RTTMs = g.double()
+				case 4:
+					p.ICMPType = g.int()
+				case 5:
+					p.ICMPCode = g.int()
+				case 6:
+					p.Timeout = g.bool()
+				case 7:
+					p.SentAt, err = g.time()
+				case 8:
+					p.ReceivedAt, err = g.time()
+				case 9:
+					p.RTTNs = int64(g.v)
+				case 10:
+					p.Iface = g.str()
+				case 11:
+					p.Port = g.int()
+				case 12:
+					p.ReplyTTL = g.int()
+				case 13:
+					p.QuotedTTL = g.int()
+				}
+				return err
+			})
+			h.Probes = append(h.Probes, p)
+		case 28:
+			h.LoadBalancing = f.str()
+		case 29:
+			h.Responders = append(h.Responders, f.str())
+		case 30:
+			h.Link = &LinkEstimate{}
+			err = pbFields(f.data, func(g pbField) error {
+				switch g.num {
+				case 1:
+					h.Link.BandwidthBps = g.double()
+				case 2:
+					h.Link.BandwidthLowBps = g.double()
+				case 3:
+					h.Link.BandwidthHighBps = g.double()
+				case 4:
+					h.Link.LatencyMs = g.double()
+				}
+				return nil
+			})
+		case 31:
+			if f.wire != wireBytes {
+				h.Ports = append(h.Ports, f.int())
+				break
+			}
+			for data := f.data; len(data) > 0; {
+				v, n := binary.Uvarint(data)
+				if n <= 0 {
+					return errPBTruncated
+				}
+				h.Ports, data = append(h.Ports, int(int64(v))), data[n:]
+			}
+		case 32:
+			h.Ping = &HopPing{}
+			err = pbFields(f.data, func(g pbField) error {
+				var err error
+				switch g.num {
+				case 1:
+					h.Ping.Sent = g.int()
+				case 2:
+					h.Ping.Received = g.int()
+				case 3:
+					h.Ping.RTTMs = g.double()
+				case 4:
+					h.Ping.RTTsMs, err = g.doubles(h.Ping.RTTsMs)
+				case 5:
+					h.Ping.Rejected = g.int()
+				case 6:
+					h.Ping.ReplyTTL = g.int()
+				}
+				return err
+			})
+		case 33:
+			h.ReplyTTL = f.int()
+		case 34:
+			h.QuotedTTL = f.int()
+		case 35:
+			h.HiddenHops = f.int()
+		case 36:
+			var l MPLSLabel
+			err = pbFields(f.data, func(g pbField) error {
+				switch g.num {
+				case 1:
+					l.Label = g.int()
+				case 2:
+					l.TC = g.int()
+				case 3:
+					l.S = g.bool()
+				case 4:
+					l.TTL = g.int()
+				}
+				return nil
+			})
+			h.MPLS = append(h.MPLS, l)
+		case 37:
+			h.Tunnel = f.str()
+		case 38:
+			h.NextMTU = f.int()
+		case 40:
+			h.RTTAvgMs = f.double()
+		case 41:
+			h.RTTDropped = f.int()
+		case 39:
+			h.Geo = &GeoInfo{}
+			err = pbFields(f.data, func(g pbField) error {
+				switch g.num {
+				case 1:
+					h.Geo.Lat = g.double()
+				case 2:
+					h.Geo.Lon = g.double()
+				case 3:
+					h.Geo.Label = g.str()
+				case 4:
+					h.Geo.DistanceKm = g.double()
+				case 5:
+					h.Geo.MinRTTMs = g.double()
+				case 6:
+					h.Geo.Impossible = g.bool()
+				case 7:
+					h.Geo.RadiusKm = g.double()
+				}
+				return nil
+			})
+		}
+		return err
+	})
+}
+
+// isJSON 判断结果文件的内容是 JSON 还是 protobuf：JSON 结果总是一个对象
+func isJSON(body []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(body, " \t\r\n"), []byte("{"))
+}
+
+// writePB 把结果以 protobuf 编码写出
+func writePB(w io.Writer, result *TraceResult) error {
+	var b pbBuf
+	encodeResult(&b, result)
+	_, err := w.Write(b)
+	return err
+}
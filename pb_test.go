@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fillValue 把 v 中每个导出字段都设为互不相同的非零值，
+// 新增字段忘了写进 pb.go 时往返测试就会失败。
+// depth 限制 Reverse 这样指向同一类型的字段，只填一层。
+func fillValue(v reflect.Value, n *int, depth int) {
+	*n++
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("s" + strconv.Itoa(*n))
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(*n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(*n))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(*n) + 0.25)
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			v.Set(reflect.ValueOf(time.Date(2026, 1, 2, 3, 4, 5, *n, time.UTC)))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fillValue(v.Field(i), n, depth)
+			}
+		}
+	case reflect.Pointer:
+		if depth > 0 && v.Type().Elem() == reflect.TypeOf(TraceResult{}) {
+			return
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		fillValue(v.Elem(), n, depth+1)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		for i := 0; i < v.Len(); i++ {
+			fillValue(v.Index(i), n, depth)
+		}
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for i := 0; i < 2; i++ {
+			key, elem := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+			fillValue(key, n, depth)
+			fillValue(elem, n, depth)
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	}
+}
+
+// fullResult 返回每个字段都有值的结果
+func fullResult() *TraceResult {
+	var r TraceResult
+	n := 0
+	fillValue(reflect.ValueOf(&r).Elem(), &n, 0)
+	return &r
+}
+
+// sameJSON 比较两个结果的 JSON 形式，出错时指出第一处不同
+func sameJSON(t *testing.T, got, want *TraceResult) {
+	t.Helper()
+	g, _ := json.MarshalIndent(got, "", " ")
+	w, _ := json.MarshalIndent(want, "", " ")
+	if bytes.Equal(g, w) {
+		return
+	}
+	gl, wl := bytes.Split(g, []byte("\n")), bytes.Split(w, []byte("\n"))
+	for i := range min(len(gl), len(wl)) {
+		if !bytes.Equal(gl[i], wl[i]) {
+			t.Fatalf("第 %d 行不同:\n得到 %s\n期望 %s", i+1, gl[i], wl[i])
+		}
+	}
+	t.Fatalf("长度不同：得到 %d 行，期望 %d 行", len(gl), len(wl))
+}
+
+func TestPBRoundTrip(t *testing.T) {
+	want := fullResult()
+	if want.Reverse == nil || len(want.Reverse.Hops) == 0 || len(want.Hops[0].Probes) == 0 {
+		t.Fatal("fillValue 没有填充嵌套的字段")
+	}
+	var buf bytes.Buffer
+	if err := writePB(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	var got TraceResult
+	if err := decodeResult(buf.Bytes(), &got); err != nil {
+		t.Fatalf("decodeResult: %v", err)
+	}
+	sameJSON(t, &got, want)
+}
+
+// render 读取 --output pb= 保存的文件，包括 gzip 压缩的
+func TestPBLoadResult(t *testing.T) {
+	want := fullResult()
+	want.SchemaVersion = resultSchemaVersion
+	want.Reverse.SchemaVersion = resultSchemaVersion
+	for _, name := range []string{"result.pb", "result.pb.gz"} {
+		path := filepath.Join(t.TempDir(), name)
+		w, err := createOutput(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writePB(w, want); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadResult(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sameJSON(t, got, want)
+	}
+}
+
+func TestPBDecodeErrors(t *testing.T) {
+	var buf bytes.Buffer
+	writePB(&buf, fullResult())
+	full := buf.Bytes()
+	for _, n := range []int{1, len(full) / 2, len(full) - 1} {
+		var r TraceResult
+		if err := decodeResult(full[:n], &r); err == nil {
+			t.Errorf("截断到 %d 字节的消息没有报错", n)
+		}
+	}
+
+	// 不认识的字段被跳过，新版本写出的结果旧版本仍然能读
+	var b pbBuf
+	b.string(1000, "future")
+	b.string(3, "example.com")
+	var r TraceResult
+	if err := decodeResult(b, &r); err != nil || r.Target != "example.com" {
+		t.Errorf("跳过未知字段失败: %v, target=%q", err, r.Target)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runPipe 实现 pipe 子命令：从标准输入逐行读取目标，直到输入结束，
+// 每完成一个目标就向标准输出写一行 JSON 结果（NDJSON），可以放在 shell 管道中间，
+// 上游是发现目标的工具，下游是 jq 或入库脚本。空行和 # 开头的行被跳过。
+//
+// 每个目标由一个单独的 trace 子进程探测，参数原样传给它：trace 的选项都可以使用，
+// 每次探测都重新创建原始套接字并放弃权限，某个目标解析失败之类的错误也不会中断整个管道。
+// 失败的目标同样输出一行，只有 target 和 errors。
+func runPipe(args []string) {
+	// pipe 没有自己的选项，补全脚本中列出 trace 的选项
+	if collectFlags != nil {
+		runTraceCommand(nil)
+		return
+	}
+	for _, arg := range args {
+		if arg == "-h" || arg == "-help" || arg == "--help" {
+			fmt.Fprintf(os.Stderr, "用法: go run . pipe [trace 的选项] < 目标列表\n\n"+
+				"从标准输入逐行读取目标，每完成一个就输出一行 JSON 结果（NDJSON），直到输入结束。\n"+
+				"选项原样传给每个目标的 trace 子进程，使用 \"go run . trace -h\" 查看。\n")
+			return
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("错误：找不到本程序的路径: %v", err)
+	}
+	dir, err := os.MkdirTemp("", "udp-traceroute-pipe-")
+	if err != nil {
+		log.Fatalf("错误：%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	in := bufio.NewScanner(os.Stdin)
+	enc := json.NewEncoder(os.Stdout)
+	for n := 1; in.Scan(); n++ {
+		target := strings.TrimSpace(in.Text())
+		if target == "" || strings.HasPrefix(target, "#") {
+			continue
+		}
+		result := pipeTrace(exe, args, target, filepath.Join(dir, fmt.Sprintf("%d.json", n)))
+		// 标准输出没有缓冲，每个结果写完立即可以被下游读到
+		if err := enc.Encode(result); err != nil {
+			log.Fatalf("错误：写出结果失败: %v", err)
+		}
+	}
+	if err := in.Err(); err != nil {
+		log.Fatalf("错误：读取标准输入失败: %v", err)
+	}
+}
+
+// pipeTrace 运行一个 trace 子进程探测 target，结果经由 --save 写进 path 再读回来。
+// 子进程的标准错误照常转给用户，失败时其中最后一行作为错误信息。
+func pipeTrace(exe string, args []string, target, path string) *TraceResult {
+	defer os.Remove(path)
+	cmdArgs := append([]string{"trace"}, args...)
+	cmdArgs = append(cmdArgs, "--quiet", "--save="+path, "--", target)
+	cmd := exec.Command(exe, cmdArgs...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	os.Stderr.Write(stderr.Bytes())
+
+	msg := runErr
+	if runErr == nil {
+		result, err := loadResult(path)
+		if err == nil {
+			return result
+		}
+		msg = err
+	}
+	if lines := strings.Split(strings.TrimSpace(stderr.String()), "\n"); lines[len(lines)-1] != "" {
+		msg = errors.New(strings.TrimPrefix(trimLogPrefix(lines[len(lines)-1]), "错误："))
+	}
+	return &TraceResult{
+		SchemaVersion: resultSchemaVersion,
+		Target:        target,
+		Hops:          []Hop{},
+		Errors:        []TraceError{{Code: errTraceFailed, Message: msg.Error(), Retryable: true}},
+	}
+}
+
+// trimLogPrefix 去掉 log 包在每行开头加的日期和时间
+func trimLogPrefix(line string) string {
+	const layout = "2006/01/02 15:04:05 "
+	if len(line) > len(layout) {
+		if _, err := time.Parse(layout, line[:len(layout)]); err == nil {
+			return line[len(layout):]
+		}
+	}
+	return line
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsePorts 解析 --ports 的逗号分隔端口列表，重复的端口只保留第一次出现的位置
+func parsePorts(spec string) ([]int, error) {
+	var ports []int
+	seen := map[int]bool{}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		port, err := strconv.Atoi(s)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("无效的端口 %q，端口必须在 1 到 65535 之间", s)
+		}
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// answeredPorts 按第一次收到回应的顺序列出这一跳中得到回应的目标端口
+func answeredPorts(probes []ProbeRecord) []int {
+	var ports []int
+	seen := map[int]bool{}
+	for _, p := range probes {
+		if !p.Timeout && !seen[p.Port] {
+			seen[p.Port] = true
+			ports = append(ports, p.Port)
+		}
+	}
+	return ports
+}
+
+// joinPorts 把端口列表格式化成 53,123,443 的形式
+func joinPorts(ports []int) string {
+	s := make([]string, len(ports))
+	for i, p := range ports {
+		s[i] = strconv.Itoa(p)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+// dropPrivileges 在不支持 setuid 的平台上什么也不做
+func dropPrivileges(username string) error {
+	return nil
+}
@@ -0,0 +1,50 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges 在原始套接字创建完成之后，把进程身份切换为指定的非特权用户。
+// 此后程序只需要创建普通UDP套接字和解析收到的ICMP报文，
+// 即使解析代码存在缺陷，攻击者也拿不到 root 权限。
+// 只有以 root 身份运行且 username 非空时才会切换。
+func dropPrivileges(username string) error {
+	if username == "" || os.Geteuid() != 0 {
+		return nil
+	}
+	u, err := user.Lookup(username)
+	if err != nil {
+		return fmt.Errorf("查找用户 %q 失败: %w", username, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("用户 %q 的 UID 无效: %w", username, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("用户 %q 的 GID 无效: %w", username, err)
+	}
+	if uid == 0 {
+		// 目标用户本身就是 root，相当于不切换
+		return nil
+	}
+
+	// 顺序很重要：必须先清理附加组并设置GID，最后再设置UID，
+	// 否则失去 root 之后就没有权限修改组了。
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("设置附加组失败: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("设置 GID 为 %d 失败: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("设置 UID 为 %d 失败: %w", uid, err)
+	}
+	return nil
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"udp-traceroute/icmpreply"
+)
+
+// probeReply 描述一个探测包收到的ICMP回应
+type probeReply struct {
+	Peer net.IP        // 返回ICMP消息的主机地址
+	Type ipv4.ICMPType // ICMP类型
+	Code int           // ICMP代码
+	// RTT 是从发送探测包到收到回应的时间。它由两个 time.Now() 相减得到，
+	// Go 在这种情况下使用单调时钟，探测途中系统时间被 NTP 调整也不会得到负数或离谱的值。
+	RTT time.Duration
+
+	SentAt     time.Time // 发送探测包的时刻
+	ReceivedAt time.Time // 收到回应的时刻
+	Interface  string    // 收到回应的接口，只有 --af-packet 时才知道
+
+	// ReplyTTL 是回应到达本机时IP头中剩余的TTL，QuotedTTL 是回应引用的探测包IP头中的TTL，
+	// 0 表示不知道。隐藏跳和 MPLS 隧道的推断（--tunnels）依靠它们。
+	ReplyTTL  int
+	QuotedTTL int
+	MPLS      []MPLSLabel // 回应中 RFC 4950 扩展携带的 MPLS 标签栈
+
+	// NextHopMTU 是“需要分片”消息中路由器报告的下一跳 MTU，0 表示没有
+	NextHopMTU int
+
+	// FromTarget 表示回应来自目标本身：ICMP Echo Reply，或者 TCP 的 SYN-ACK、RST。
+	// 只有 --fallback 使用的 altProber 会收到这类回应，它们和端口不可达一样表示到达了终点。
+	FromTarget bool
+}
+
+// prober 负责发送一个指定TTL的UDP探测包，并等待对应的ICMP回应。
+// 不同的实现对应不同的套接字权限要求。
+// 同一个 prober 发出的探测包源端口保持不变，因此目标端口就决定了探测包所属的“流”。
+type prober interface {
+	// probe 向 dest 发送一个以 payload 为负载的探测包。超时未收到回应时返回 (nil, nil)。
+	probe(ttl int, dest *net.UDPAddr, payload []byte) (*probeReply, error)
+	// mode 返回当前使用的收包方式，用于提示用户
+	mode() string
+	// drainEvents 取出自上次调用以来记录的迟到和重复回应
+	drainEvents() []ReplyEvent
+	// rejected 返回按原因统计的、被当作无关或伪造报文丢弃的ICMP消息数量
+	rejected() map[string]int
+	// lastSeq 返回最近一个探测包的序号，无论它是否收到了回应
+	lastSeq() uint16
+	Close() error
+}
+
+// unprivilegedProbers 按优先顺序列出无需特权的收包方式，
+// 每个平台上不支持的方式会直接返回错误
+var unprivilegedProbers = []func(probeConfig) (prober, error){newRecvErrProber, newDgramProber}
+
+// openProber 检测实际可用的套接字并选择最好的收包方式：
+// 优先使用原始ICMP套接字；没有权限时给出针对当前平台的解决办法，
+// 然后依次尝试 unprivilegedProbers，使用第一个可用的，并告诉用户选择了哪一种。
+func openProber(cfg probeConfig) (prober, error) {
+	p, err := newRawProber(cfg)
+	if err == nil {
+		return p, nil
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		return nil, fmt.Errorf("创建ICMP监听连接失败: %w", err)
+	}
+	// 自己构造报文是用户明确要求的探测方式，没有替代品；换一种方式接收回应则不影响结果
+	if cfg.RawSend {
+		return nil, fmt.Errorf("--raw-send 需要原始套接字权限。%s", permissionRemedy())
+	}
+
+	fmt.Fprintf(os.Stderr, "警告：没有创建原始ICMP套接字的权限。%s\n", permissionRemedy())
+	if cfg.PacketCapture {
+		fmt.Fprintf(os.Stderr, "警告：--af-packet 同样需要该权限，已忽略。\n")
+	}
+	var failures []string
+	for _, open := range unprivilegedProbers {
+		fallback, ferr := open(cfg)
+		if ferr != nil {
+			failures = append(failures, ferr.Error())
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "已自动切换到无需特权的 %s 模式。\n", fallback.mode())
+		return fallback, nil
+	}
+	return nil, fmt.Errorf("创建ICMP监听连接失败: %w；无需特权的方式也不可用: %s", err, strings.Join(failures, "；"))
+}
+
+// usesRawSockets 判断探测器是否使用原始套接字，--fallback、--dest-ping 等功能需要同样的权限
+func usesRawSockets(p prober) bool {
+	switch p.(type) {
+	case *rawProber, *altProber:
+		return true
+	}
+	return false
+}
+
+// permissionRemedy 返回当前平台上获取原始套接字权限的具体办法
+func permissionRemedy() string {
+	switch runtime.GOOS {
+	case "linux":
+		exe, err := os.Executable()
+		if err != nil {
+			exe = "<程序路径>"
+		}
+		return fmt.Sprintf("请使用 sudo 运行，或者为程序授予 CAP_NET_RAW 能力: sudo setcap cap_net_raw+ep %s", exe)
+	case "windows":
+		return "请在“以管理员身份运行”的终端中执行本程序。"
+	default:
+		return "请使用 sudo 以 root 身份运行。"
+	}
+}
+
+// rawProber 使用原始ICMP套接字接收所有ICMP消息，需要 root 或 CAP_NET_RAW
+type rawProber struct {
+	icmpConn net.PacketConn
+	sendConn *ipv4.PacketConn
+	buf      []byte     // 接收缓冲区
+	srcPort  int        // 探测包的源端口，回应中引用的必须是它
+	verify   bool       // 是否检查响应者地址的可信度
+	debug    bool       // 是否转储收发的报文
+	traceID  uint32     // 写进探测包负载的 trace 标识
+	raw      *rawSender // 不为 nil 时自己构造IP头发送探测包（--raw-send），序号同时写进 IP ID
+
+	rejects map[string]int // 按原因统计的被丢弃的ICMP消息
+
+	seq     uint16                // 最近一个探测包的序号
+	sent    map[uint16]*sentProbe // 已发送的探测包，按序号索引，用于识别迟到和重复的回应
+	warmups map[uint16]bool       // 预热探测包的序号，它们不在 sent 中，迟到的回应直接忽略
+	events  []ReplyEvent          // 尚未被取走的迟到/重复回应
+}
+
+// sentProbe 记录一个已发出的探测包
+type sentProbe struct {
+	ttl      int
+	port     int // 目标端口
+	sentAt   time.Time
+	answered bool // 是否已经收到过回应
+}
+
+// newRawProber 准备一个专门用来接收ICMP返回包的连接。
+// traceroute的原理就是发送UDP包并监听ICMP错误，所以收发是分离的。
+// "ip4:icmp" 表示监听IPv4协议中的所有ICMP类型的包。
+// "0.0.0.0" 表示监听本机所有网络接口。
+//
+// 用来发送探测包的UDP套接字也在这里一次性创建，之后每次探测只修改TTL。
+// 这样像 SO_MARK 这类需要特权的选项可以在放弃 root 权限之前设置好。
+func newRawProber(cfg probeConfig) (*rawProber, error) {
+	var icmpConn net.PacketConn
+	var err error
+	if cfg.PacketCapture {
+		icmpConn, err = newPacketCapture()
+	} else {
+		var c net.PacketConn
+		if c, err = cfg.listenPacket("ip4:icmp", "0.0.0.0"); err == nil {
+			icmpConn = newTTLConn(c)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 监听 "0.0.0.0:0" 表示让操作系统在所有网络接口上为我们选择一个随机的可用端口
+	sendSocket, err := cfg.listenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		icmpConn.Close()
+		return nil, fmt.Errorf("创建UDP发送连接失败: %w", err)
+	}
+	srcPort := sendSocket.LocalAddr().(*net.UDPAddr).Port
+	// 自己构造IP头时，UDP套接字仍然保留着，它占住源端口，不让其他程序使用
+	var raw *rawSender
+	if cfg.RawSend {
+		if raw, err = newRawSender(cfg, srcPort); err != nil {
+			sendSocket.Close()
+			icmpConn.Close()
+			return nil, err
+		}
+	}
+	// 1. 将标准的 net.PacketConn 包装成 ipv4.PacketConn
+	// 2. 这样我们就能获得对IP协议头部的控制权，特别是设置TTL
+	return &rawProber{
+		icmpConn: icmpConn,
+		sendConn: ipv4.NewPacketConn(sendSocket),
+		buf:      make([]byte, maxPacketLen),
+		srcPort:  srcPort,
+		raw:      raw,
+		verify:   cfg.VerifyResponders,
+		debug:    cfg.DebugPackets,
+		traceID:  cfg.TraceID,
+		rejects:  make(map[string]int),
+		sent:     make(map[uint16]*sentProbe),
+		warmups:  make(map[uint16]bool),
+	}, nil
+}
+
+// ttlReader 由能同时给出报文IP头中TTL的接收套接字实现
+type ttlReader interface {
+	readFromTTL(b []byte) (n, ttl int, addr net.Addr, err error)
+}
+
+// ttlConn 通过控制消息（IP_RECVTTL）让原始ICMP套接字同时给出每个回应的IP TTL，
+// 平台不支持时 TTL 为 0
+type ttlConn struct {
+	net.PacketConn
+	p *ipv4.PacketConn
+}
+
+func newTTLConn(c net.PacketConn) *ttlConn {
+	p := ipv4.NewPacketConn(c)
+	p.SetControlMessage(ipv4.FlagTTL, true)
+	return &ttlConn{PacketConn: c, p: p}
+}
+
+func (c *ttlConn) readFromTTL(b []byte) (int, int, net.Addr, error) {
+	n, cm, addr, err := c.p.ReadFrom(b)
+	ttl := 0
+	if cm != nil {
+		ttl = cm.TTL
+	}
+	return n, ttl, addr, err
+}
+
+// readFromTTL 从 c 读取一个报文，c 不能给出TTL时返回的TTL为 0
+func readFromTTL(c net.PacketConn, b []byte) (int, int, net.Addr, error) {
+	if r, ok := c.(ttlReader); ok {
+		return r.readFromTTL(b)
+	}
+	n, addr, err := c.ReadFrom(b)
+	return n, 0, addr, err
+}
+
+func (r *rawProber) mode() string { return "原始ICMP套接字" }
+
+func (r *rawProber) drainEvents() []ReplyEvent {
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func (r *rawProber) rejected() map[string]int { return r.rejects }
+
+func (r *rawProber) lastSeq() uint16 { return r.seq }
+
+// reset 清空已发送探测包的记录、未取走的事件和丢弃统计，开始一次新的 trace。
+// 序号继续递增而不从头开始，上一次 trace 迟到的回应因此对不上任何记录，被当作无关消息丢弃。
+func (r *rawProber) reset() {
+	r.sent = make(map[uint16]*sentProbe)
+	r.warmups = make(map[uint16]bool)
+	r.events = nil
+	r.rejects = make(map[string]int)
+}
+
+// reject 按原因统计一个被丢弃的ICMP消息
+func (r *rawProber) reject(reason string) {
+	r.rejects[reason]++
+	if r.debug {
+		debugVerdict("丢弃：%s", rejectDescriptions[reason])
+	}
+}
+
+func (r *rawProber) Close() error {
+	if r.raw != nil {
+		r.raw.Close()
+	}
+	r.sendConn.Close()
+	return r.icmpConn.Close()
+}
+
+// warmUp 发送一个不做记录的探测包（--warmup）：发送后把它从 sent 中移除，
+// 它迟到的回应既不算作迟到事件，也不计入被丢弃的消息
+func (r *rawProber) warmUp(ttl int, dest *net.UDPAddr) {
+	r.probe(ttl, dest, nil)
+	delete(r.sent, r.seq)
+	r.warmups[r.seq] = true
+}
+
+func (r *rawProber) probe(ttl int, dest *net.UDPAddr, payload []byte) (*probeReply, error) {
+	if r.raw == nil {
+		if err := r.sendConn.SetTTL(ttl); err != nil {
+			return nil, fmt.Errorf("设置TTL为 %d 失败: %w", ttl, err)
+		}
+	}
+
+	// 为探测包分配序号并写进负载，回应中引用的原始数据报会带着它回来。
+	// 序号 0 保留给“未知”，回绕时跳过。
+	r.seq++
+	if r.seq == 0 {
+		r.seq = 1
+	}
+	seq := r.seq
+	payload = stampProbeID(payload, seq, r.traceID)
+
+	// 发送探测包。通常负载里只有序号，因为我们只关心IP头和UDP头。
+	// 记录发送时间，用于计算往返时延
+	if r.debug {
+		debugSent(r.srcPort, dest, ttl, seq, payload)
+	}
+	sentAt := time.Now()
+	var err error
+	if r.raw != nil {
+		err = r.raw.send(ttl, dest, seq, payload)
+	} else {
+		_, err = r.sendConn.WriteTo(payload, nil, dest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("发送UDP探测包失败: %w", err)
+	}
+	current := &sentProbe{ttl: ttl, port: dest.Port, sentAt: sentAt}
+	r.sent[seq] = current
+
+	// ---- 发送完成，现在开始等待回应 ----
+
+	// 为本次接收操作设置一个超时期限
+	r.icmpConn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		// 缓冲区按IP包的最大长度分配：引用了很长数据的ICMP回应可能是分片到达的，
+		// 内核会先把它们重组成完整的数据报再交给原始套接字，
+		// 如果缓冲区太小，重组后的报文会被截断，校验和也就对不上了
+		replyBytes := r.buf
+
+		// 阻塞式读取ICMP连接，直到收到数据包或超时
+		n, replyTTL, peerAddr, err := readFromTTL(r.icmpConn, replyBytes)
+		if err != nil {
+			// 如果错误是网络超时错误，说明这一跳的路由器没有回应
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("读取ICMP回应时出错: %w", err)
+		}
+		receivedAt := time.Now()
+
+		// 只有实际读到的 n 个字节才是这条消息，icmpreply.Parse 会先检查长度和校验和，
+		// 被截断或损坏的报文计入统计后丢弃，不影响继续等待真正的回应
+		// peerAddr 是返回ICMP消息的主机IP地址，即当前这一跳的路由器地址
+		// 用 --af-packet 接收时，地址的 Zone 是收到回应的接口名
+		peer, iface := peerAddr.(*net.IPAddr).IP, peerAddr.(*net.IPAddr).Zone
+		reply, err := icmpreply.Parse(replyBytes[:n])
+		if r.debug {
+			debugReceived(peer, replyBytes[:n], reply, err)
+		}
+		if err != nil {
+			r.reject(rejectMalformed)
+			continue
+		}
+
+		// 原始套接字会收到本机所有的ICMP消息，只有引用了我们自己探测包的才可信：
+		// 引用的数据报必须发往目标地址、源端口是我们的端口，并且序号和目标端口
+		// 对得上某个已发出的探测包。其余的消息可能来自别的程序，也可能是伪造的。
+		q := quotedProbeFrom(reply.Quote)
+		if q == nil {
+			// --dest-ping 的 Echo Reply 和拒绝它的差错消息也会送到这里，它们是自己的，不算被丢弃
+			if !isDestPingReply(replyBytes[:n], r.traceID) && !quotesDestPing(reply.Quote, r.traceID) {
+				r.reject(rejectNoQuote)
+			}
+			continue
+		}
+		// 自己构造IP头时，序号也在引用一定会包含的 IP ID 里。
+		// 它对得上某个已发出的探测包时就不再要求端口一致，
+		// 这样中间设备改写了UDP端口的回应也能对应上。
+		trustID := false
+		if r.raw != nil {
+			q.Seq = uint16(reply.Quote.ID)
+			_, trustID = r.sent[q.Seq]
+		}
+		checkPorts := q.HasPorts && !trustID
+		if !q.Dst.Equal(dest.IP) || (checkPorts && q.SrcPort != r.srcPort) {
+			r.reject(rejectMismatch)
+			continue
+		}
+		if q.TraceID != 0 && q.TraceID != r.traceID {
+			r.reject(rejectOtherTrace)
+			continue
+		}
+		if r.verify && !plausibleResponder(peer, dest.IP, reply.Type, reply.Code) {
+			r.reject(rejectImplausible)
+			continue
+		}
+
+		// 回应引用的是更早的探测包：它要么已经被判定为超时（迟到），
+		// 要么已经收到过回应（重复）。记录下来，但不能算到当前这一跳头上。
+		if q.Seq != 0 && q.Seq != seq {
+			if r.warmups[q.Seq] {
+				continue
+			}
+			earlier, ok := r.sent[q.Seq]
+			if !ok || (checkPorts && q.DstPort != earlier.port) {
+				r.reject(rejectUnknownProbe)
+				continue
+			}
+			kind := replyLate
+			if earlier.answered {
+				kind = replyDuplicate
+			}
+			earlier.answered = true
+			if r.debug {
+				label := "迟到"
+				if kind == replyDuplicate {
+					label = "重复"
+				}
+				debugVerdict("%s：第 %d 跳的探测包 seq=%d", label, earlier.ttl, q.Seq)
+			}
+			r.events = append(r.events, ReplyEvent{
+				Kind:  kind,
+				TTL:   earlier.ttl,
+				Addr:  peer.String(),
+				RTTMs: float64(receivedAt.Sub(earlier.sentAt)) / float64(time.Millisecond),
+			})
+			continue
+		}
+		// 引用的数据太短、没有带上序号时，只能依靠端口来判断；
+		// 连端口都没有时（例如引用的是非首个分片），只能依靠目标地址
+		if checkPorts && q.DstPort != dest.Port {
+			r.reject(rejectUnknownProbe)
+			continue
+		}
+
+		current.answered = true
+		if r.debug {
+			debugVerdict("接受：第 %d 跳的回应", ttl)
+		}
+		return &probeReply{
+			Peer: peer,
+			Type: reply.Type,
+			Code: reply.Code,
+			RTT:  receivedAt.Sub(sentAt),
+
+			SentAt:     sentAt,
+			ReceivedAt: receivedAt,
+			Interface:  iface,
+
+			ReplyTTL:  replyTTL,
+			QuotedTTL: reply.Quote.TTL,
+			MPLS:      mplsLabels(reply.Extensions),
+
+			NextHopMTU: reply.NextHopMTU,
+		}, nil
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+import "errors"
+
+// newDgramProber 在 Linux 上不使用：ICMP 数据报套接字收到的差错消息同样要经过 IP_RECVERR，
+// 而 newRecvErrProber 的UDP方式不受 ping_group_range 限制，总是优先可用
+func newDgramProber(cfg probeConfig) (prober, error) {
+	return nil, errors.New("Linux 上无需特权时使用 IP_RECVERR 方式")
+}
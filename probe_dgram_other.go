@@ -0,0 +1,127 @@
+//go:build !linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+
+	"udp-traceroute/icmpreply"
+)
+
+// dgramProber 是 macOS 等平台上无需特权的探测方式：
+// 用 ICMP 数据报套接字（SOCK_DGRAM + IPPROTO_ICMP）发送 ICMP Echo 探测包，
+// 内核会把引用了本套接字报文的 Time Exceeded 等差错消息交给它。
+// 探测包是 ICMP Echo 而不是UDP，目标本身回应的是 Echo Reply。
+type dgramProber struct {
+	conn    *icmp.PacketConn
+	buf     []byte
+	traceID uint32
+	debug   bool
+	seq     uint16 // 最近一个探测包的序号，写在 Echo 的序号字段中
+}
+
+func newDgramProber(cfg probeConfig) (prober, error) {
+	c, err := icmp.ListenPacket("udp4", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("创建ICMP数据报套接字失败: %w", err)
+	}
+	return &dgramProber{conn: c, buf: make([]byte, maxPacketLen), traceID: cfg.TraceID, debug: cfg.DebugPackets}, nil
+}
+
+func (d *dgramProber) mode() string { return "ICMP 数据报套接字（ICMP Echo 探测）" }
+
+// drainEvents 总是返回空：不是当前序号的回应直接忽略
+func (d *dgramProber) drainEvents() []ReplyEvent { return nil }
+
+// rejected 总是返回空：内核只交回与本套接字有关的消息
+func (d *dgramProber) rejected() map[string]int { return nil }
+
+func (d *dgramProber) lastSeq() uint16 { return d.seq }
+
+func (d *dgramProber) Close() error { return d.conn.Close() }
+
+// probe 向 dest.IP 发送一个 ICMP Echo 探测包，dest.Port 和 payload 不使用
+func (d *dgramProber) probe(ttl int, dest *net.UDPAddr, payload []byte) (*probeReply, error) {
+	if err := d.conn.IPv4PacketConn().SetTTL(ttl); err != nil {
+		return nil, fmt.Errorf("设置TTL为 %d 失败: %w", ttl, err)
+	}
+	d.seq++
+	if d.seq == 0 {
+		d.seq = 1
+	}
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: int(uint16(d.traceID)), Seq: int(d.seq), Data: stampProbeID(nil, d.seq, d.traceID)},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	sentAt := time.Now()
+	if _, err := d.conn.WriteTo(b, &net.UDPAddr{IP: dest.IP}); err != nil {
+		return nil, fmt.Errorf("发送 ICMP Echo 探测包失败: %w", err)
+	}
+
+	d.conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		n, addr, err := d.conn.ReadFrom(d.buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("读取ICMP回应时出错: %w", err)
+		}
+		receivedAt := time.Now()
+		var peer net.IP
+		switch a := addr.(type) {
+		case *net.UDPAddr:
+			peer = a.IP
+		case *net.IPAddr:
+			peer = a.IP
+		}
+		reply, err := icmpreply.Parse(d.buf[:n])
+		if d.debug {
+			debugReceived(peer, d.buf[:n], reply, err)
+		}
+		if err != nil {
+			continue
+		}
+		r := d.match(reply, d.buf[:n], peer, dest.IP)
+		if r == nil {
+			continue
+		}
+		r.RTT = receivedAt.Sub(sentAt)
+		r.SentAt, r.ReceivedAt = sentAt, receivedAt
+		return r, nil
+	}
+}
+
+// match 检查消息是否是当前探测包的回应：目标回应的 Echo Reply，
+// 或者引用了当前 Echo 请求的差错消息。内核可能改写 Echo 的标识符，所以只比较序号。
+func (d *dgramProber) match(reply *icmpreply.Reply, b []byte, peer, dest net.IP) *probeReply {
+	if reply.Type == ipv4.ICMPTypeEchoReply {
+		if !peer.Equal(dest) || binary.BigEndian.Uint16(b[6:8]) != d.seq {
+			return nil
+		}
+		return &probeReply{Peer: peer, Type: reply.Type, FromTarget: true}
+	}
+	q := reply.Quote
+	if q == nil || q.Protocol != protocolICMP || !q.Dst.Equal(dest) || len(q.Transport) < icmpreply.HeaderLen ||
+		binary.BigEndian.Uint16(q.Transport[6:8]) != d.seq {
+		return nil
+	}
+	// 引用中带有负载时，trace 标识也要对得上
+	if len(q.Transport) >= icmpreply.HeaderLen+probeIDLen &&
+		binary.BigEndian.Uint32(q.Transport[icmpreply.HeaderLen+seqLen:]) != d.traceID {
+		return nil
+	}
+	return &probeReply{Peer: peer, Type: reply.Type, Code: reply.Code}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+
+	"udp-traceroute/icmpreply"
+)
+
+// recvErrProber 是 Linux 上无需特权的收包方式：
+// 给UDP套接字打开 IP_RECVERR 选项后，内核会把与该套接字相关的
+// ICMP错误（包括 Time Exceeded）放进套接字的错误队列，
+// 程序通过 MSG_ERRQUEUE 读取即可，tracepath 用的就是这种办法。
+type recvErrProber struct {
+	cfg     probeConfig
+	srcPort int    // 第一次探测时由系统分配的源端口，之后的探测沿用它，保证流标识不变
+	seq     uint16 // 最近一个探测包的序号
+}
+
+// newRecvErrProber 检查当前内核是否支持 IP_RECVERR，
+// 同时用 cfg 创建一个套接字，让选项方面的问题（例如没有权限设置 SO_MARK）在启动时就暴露出来
+func newRecvErrProber(cfg probeConfig) (prober, error) {
+	r := &recvErrProber{cfg: cfg}
+	conn, _, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	r.srcPort = conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+	return r, nil
+}
+
+// open 创建一个打开了 IP_RECVERR 的UDP套接字
+func (r *recvErrProber) open() (net.PacketConn, syscall.RawConn, error) {
+	conn, err := r.cfg.listenPacket("udp4", fmt.Sprintf("0.0.0.0:%d", r.srcPort))
+	if err != nil && r.srcPort != 0 {
+		// 原来的端口被别的程序占用了，只能换一个
+		conn, err = r.cfg.listenPacket("udp4", "0.0.0.0:0")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建UDP发送连接失败: %w", err)
+	}
+	rawConn, err := conn.(*net.UDPConn).SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	var serr error
+	rawConn.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_RECVERR, 1)
+	})
+	if serr != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("内核不支持 IP_RECVERR: %w", serr)
+	}
+	return conn, rawConn, nil
+}
+
+func (r *recvErrProber) mode() string { return "UDP IP_RECVERR" }
+
+func (r *recvErrProber) Close() error { return nil }
+
+// drainEvents 总是返回空：每次探测使用独立的套接字并在结束时关闭，
+// 迟到的回应根本不会被送到程序里
+func (r *recvErrProber) drainEvents() []ReplyEvent { return nil }
+
+// rejected 总是返回空：错误队列中的消息已经由内核按套接字匹配过了
+func (r *recvErrProber) rejected() map[string]int { return nil }
+
+func (r *recvErrProber) lastSeq() uint16 { return r.seq }
+
+func (r *recvErrProber) probe(ttl int, dest *net.UDPAddr, payload []byte) (*probeReply, error) {
+	// 每次探测使用独立的UDP套接字，这样错误队列里只会有本次探测的回应。
+	// 套接字都绑定在同一个源端口上，因此端口号不会因为重新创建而改变。
+	conn, rawConn, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	p := ipv4.NewPacketConn(conn)
+	if err := p.SetTTL(ttl); err != nil {
+		return nil, fmt.Errorf("设置TTL为 %d 失败: %w", ttl, err)
+	}
+	// 错误队列只会交回本套接字的探测包，序号不用于匹配，只是让探测包可以被识别
+	r.seq++
+	if r.seq == 0 {
+		r.seq = 1
+	}
+	payload = stampProbeID(payload, r.seq, r.cfg.TraceID)
+	if r.cfg.DebugPackets {
+		debugSent(r.srcPort, dest, ttl, r.seq, payload)
+	}
+	sentAt := time.Now()
+	if _, err := p.WriteTo(payload, nil, dest); err != nil {
+		return nil, fmt.Errorf("发送UDP探测包失败: %w", err)
+	}
+
+	// 错误队列有数据时套接字会变为可读（POLLERR），
+	// 因此可以直接借助 Go 的网络轮询器和读超时来等待
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1500)
+	oob := make([]byte, 512)
+	var n, oobn int
+	var rerr error
+	err = rawConn.Read(func(fd uintptr) bool {
+		n, oobn, _, _, rerr = unix.Recvmsg(int(fd), buf, oob, unix.MSG_ERRQUEUE)
+		return rerr != unix.EAGAIN
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取错误队列时出错: %w", err)
+	}
+	if rerr != nil {
+		return nil, fmt.Errorf("读取错误队列时出错: %w", rerr)
+	}
+	receivedAt := time.Now()
+
+	reply, err := parseRecvErr(oob[:oobn], receivedAt.Sub(sentAt))
+	if reply != nil {
+		reply.SentAt, reply.ReceivedAt = sentAt, receivedAt
+	}
+	if r.cfg.DebugPackets {
+		debugErrQueue(buf[:n], reply, err)
+	}
+	return reply, err
+}
+
+// debugErrQueue 打印从错误队列中读到的内容。内核只交给我们
+// 原始探测包的负载和解析好的ICMP类型、代码，拿不到完整的ICMP消息。
+func debugErrQueue(payload []byte, reply *probeReply, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "<<< 错误队列: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "<<< 错误队列: 来自 %s，%s (type %d code %d)，原始负载 %d 字节\n",
+		reply.Peer, icmpreply.Describe(reply.Type, reply.Code), reply.Type, reply.Code, len(payload))
+	fmt.Fprint(os.Stderr, indentDump(payload))
+}
+
+// sizeofSockExtendedErr 是内核 struct sock_extended_err 的大小
+const sizeofSockExtendedErr = int(unsafe.Sizeof(unix.SockExtendedErr{}))
+
+// parseRecvErr 从控制消息中取出 sock_extended_err 结构和紧随其后的
+// offender 地址（即发出ICMP错误的路由器）
+func parseRecvErr(oob []byte, rtt time.Duration) (*probeReply, error) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, fmt.Errorf("解析控制消息失败: %w", err)
+	}
+	for _, m := range msgs {
+		if m.Header.Level != unix.IPPROTO_IP || m.Header.Type != unix.IP_RECVERR {
+			continue
+		}
+		if len(m.Data) < sizeofSockExtendedErr+unix.SizeofSockaddrInet4 {
+			return nil, fmt.Errorf("IP_RECVERR 数据过短: %d 字节", len(m.Data))
+		}
+		// sock_extended_err: errno(4) origin(1) type(1) code(1) pad(1) info(4) data(4)
+		origin := m.Data[4]
+		if origin != unix.SO_EE_ORIGIN_ICMP {
+			return nil, fmt.Errorf("错误并非来自ICMP (origin=%d)", origin)
+		}
+		// offender 是一个 sockaddr_in: family(2) port(2) addr(4)
+		offender := m.Data[sizeofSockExtendedErr:]
+		if binary.NativeEndian.Uint16(offender[0:2]) != unix.AF_INET {
+			return nil, fmt.Errorf("错误队列中没有发送方地址")
+		}
+		reply := &probeReply{
+			Peer: net.IPv4(offender[4], offender[5], offender[6], offender[7]),
+			Type: ipv4.ICMPType(m.Data[5]),
+			Code: int(m.Data[6]),
+			RTT:  rtt,
+		}
+		// “需要分片”时内核把路由器报告的下一跳 MTU 放在 info 字段中
+		if reply.Type == ipv4.ICMPTypeDestinationUnreachable && reply.Code == codeFragNeeded {
+			reply.NextHopMTU = int(binary.NativeEndian.Uint32(m.Data[8:12]))
+		}
+		return reply, nil
+	}
+	return nil, fmt.Errorf("错误队列中没有 IP_RECVERR 消息")
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// newRecvErrProber 在非 Linux 平台上不可用，没有无需特权的降级方式
+func newRecvErrProber(cfg probeConfig) (prober, error) {
+	return nil, errors.New("当前平台不支持 IP_RECVERR")
+}
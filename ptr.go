@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// confirmPTR 反向解析这一跳的地址，再把得到的主机名正向解析一次（--ptr-check），
+// 结果中没有这一跳的地址时标记 PTRMismatch。
+// 路由器的 PTR 记录常常在地址重新分配之后没有更新，只看名字判断设备和位置很容易被误导。
+// 查询失败（超时、SERVFAIL）不算不符，只有名字确实不存在时才算。
+// 来自 --hosts-file 的名字是用户自己给的，不做检查。
+func confirmPTR(h *Hop) {
+	lookupHost(h)
+	if h.Host == "" || localHosts.lookup(h.Addr) != "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, h.Host)
+	if err != nil {
+		var dnsErr *net.DNSError
+		h.PTRMismatch = errors.As(err, &dnsErr) && dnsErr.IsNotFound
+		return
+	}
+	ip := net.ParseIP(h.Addr)
+	for _, a := range addrs {
+		if a.IP.Equal(ip) {
+			return
+		}
+	}
+	h.PTRMismatch = true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"net"
+
+	"udp-traceroute/icmpreply"
+)
+
+// 探测包负载开头依次是序号和 trace 标识，合起来唯一地标识一个探测包：
+// 序号在一次 trace 中递增，trace 标识每次运行随机生成，
+// 这样日志、抓包文件和 JSON 结果可以精确对应到每一个报文。
+// 序号和 trace 标识的 0 值都保留，表示“无法从引用的数据中取得”。
+const (
+	seqLen     = 2
+	traceIDLen = 4
+	probeIDLen = seqLen + traceIDLen
+)
+
+// protocolUDP 是IP头中UDP的协议号
+const protocolUDP = 17
+
+// quotedProbe 是从ICMP错误消息里引用的原始数据报中还原出的探测包信息。
+// 有的设备只引用IP头加8个字节（RFC 792 的最低要求），有的引用被截断，
+// 还有的引用的是非首个分片，因此除目标地址外每一项都可能缺失。
+type quotedProbe struct {
+	Dst      net.IP // 原始数据报的目标地址
+	HasPorts bool   // 引用中是否包含UDP端口
+	SrcPort  int    // 原始UDP源端口
+	DstPort  int    // 原始UDP目标端口
+	Seq      uint16 // 负载中的探测序号，0 表示引用的数据太短、没有包含负载
+	TraceID  uint32 // 负载中的 trace 标识，0 表示引用的数据中没有
+}
+
+// quotedProbeFrom 从 icmpreply 解析出的引用中还原探测包信息，
+// 没有引用或引用的不是UDP数据报时返回 nil
+func quotedProbeFrom(q *icmpreply.Quote) *quotedProbe {
+	if q == nil || q.Protocol != protocolUDP {
+		return nil
+	}
+	p := &quotedProbe{Dst: q.Dst, HasPorts: q.HasPorts, SrcPort: q.SrcPort, DstPort: q.DstPort}
+	// UDP 长度字段告诉我们原始负载有多长，只有负载里确实带了序号、
+	// 并且引用的数据足够长时才读取
+	if q.UDPLength >= udpHeaderLen+seqLen && len(q.Payload) >= seqLen {
+		p.Seq = binary.BigEndian.Uint16(q.Payload)
+	}
+	if q.UDPLength >= udpHeaderLen+probeIDLen && len(q.Payload) >= probeIDLen {
+		p.TraceID = binary.BigEndian.Uint32(q.Payload[seqLen:])
+	}
+	return p
+}
+
+// stampProbeID 把序号和 trace 标识写进负载开头。负载为空时新建一个只含这两项的负载；
+// 负载太短（例如MTU探测中的最小包）时能写多少写多少，连序号都放不下时保持原样。
+func stampProbeID(payload []byte, seq uint16, traceID uint32) []byte {
+	if payload == nil {
+		payload = make([]byte, probeIDLen)
+	}
+	if len(payload) < seqLen {
+		return payload
+	}
+	out := make([]byte, len(payload))
+	copy(out, payload)
+	binary.BigEndian.PutUint16(out, seq)
+	if len(out) >= probeIDLen {
+		binary.BigEndian.PutUint32(out[seqLen:], traceID)
+	}
+	return out
+}
+
+// newTraceID 随机生成一个非零的 trace 标识
+func newTraceID() uint32 {
+	for {
+		if id := rand.Uint32(); id != 0 {
+			return id
+		}
+	}
+}
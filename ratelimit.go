@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+// detectRateLimiting 找出“丢包但转发正常”的跳：如果某一跳的丢包率
+// 高于它下游某个有回应的跳，说明探测包其实都被转发过去了，
+// 丢掉的只是这一跳自己生成的ICMP回应，也就是路由器的ICMP限速。
+// 这样的跳会被标记，以免用户把它当成真正的丢包点。
+func detectRateLimiting(result *TraceResult) {
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		if hop.Received == 0 || hop.LossPct == 0 {
+			continue
+		}
+		// 找下游丢包率最低的跳
+		best := -1.0
+		for _, down := range result.Hops[i+1:] {
+			if down.Received > 0 && (best < 0 || down.LossPct < best) {
+				best = down.LossPct
+			}
+		}
+		if best >= 0 && best < hop.LossPct {
+			hop.RateLimited = true
+			hop.State = hopRateLimited
+		}
+	}
+}
+
+// reportRateLimiting 打印被判定为受ICMP限速影响的跳
+func reportRateLimiting(result *TraceResult) {
+	printed := false
+	for _, hop := range result.Hops {
+		if !hop.RateLimited {
+			continue
+		}
+		if !printed {
+			fmt.Println("ICMP 限速检测:")
+			printed = true
+		}
+		fmt.Printf("%2d %-15s 受ICMP限速影响，并非真实丢包（本跳丢包 %.0f%%，下游转发正常）\n", hop.TTL, anon.addr(hop.Addr), hop.LossPct)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+
+	"udp-traceroute/icmpreply"
+)
+
+// UDP校验和的填法（--udp-checksum）
+const (
+	checksumNormal   = "normal"   // 正确计算
+	checksumZero     = "zero"     // 填 0，表示没有校验和（RFC 768 允许）
+	checksumConstant = "constant" // 负载末尾加2字节补偿，使所有探测包的校验和都等于 constantChecksum
+	checksumBad      = "bad"      // 把正确值的最低位取反，接收方会丢弃，但路由器转发和回应ICMP时不检查
+)
+
+var checksumModes = []string{checksumNormal, checksumZero, checksumConstant, checksumBad}
+
+// constantChecksum 是 constant 模式下所有探测包共同的UDP校验和，取什么值都可以
+const constantChecksum = 0x5452
+
+// rawSender 用原始套接字自己构造IP头和UDP头发送探测包（--raw-send），
+// 把探测包的序号写进IP头的标识（IP ID）字段。
+//
+// 路由器返回的ICMP错误至少会引用原始数据报的IP头和随后的8个字节，
+// 所以即使引用里没有负载、或者中间设备改写了UDP端口，
+// 也能通过 IP ID 把回应对应到具体的探测包。这是经典 traceroute 实现常用的办法。
+type rawSender struct {
+	conn     *ipv4.RawConn
+	srcPort  int
+	df       bool              // 是否设置 DF 标志（--mtu）
+	checksum string            // UDP校验和的填法
+	cfg      probeConfig       // 用于查询源地址
+	sources  map[string]net.IP // 各目标对应的源地址，IP头和UDP校验和都需要它
+}
+
+// newRawSender 创建一个协议号为 IPPROTO_RAW 的原始套接字。
+// 这种套接字总是由程序提供IP头（相当于打开了 IP_HDRINCL），并且不会收到任何报文。
+func newRawSender(cfg probeConfig, srcPort int) (*rawSender, error) {
+	c, err := cfg.listenPacket("ip4:255", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("创建原始发送套接字失败: %w", err)
+	}
+	conn, err := ipv4.NewRawConn(c)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("创建原始发送套接字失败: %w", err)
+	}
+	return &rawSender{
+		conn:     conn,
+		srcPort:  srcPort,
+		df:       cfg.DontFragment,
+		checksum: cfg.UDPChecksum,
+		cfg:      cfg,
+		sources:  make(map[string]net.IP),
+	}, nil
+}
+
+// source 返回发往 dest 的探测包应当使用的源地址：
+// 指定了 --raw-source 时就是它，否则是内核为 dest 选择的出口地址
+func (s *rawSender) source(dest net.IP) (net.IP, error) {
+	if s.cfg.RawSource != nil {
+		return s.cfg.RawSource, nil
+	}
+	if src, ok := s.sources[dest.String()]; ok {
+		return src, nil
+	}
+	egress, err := lookupEgress(dest, s.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("查询源地址失败: %w", err)
+	}
+	src := net.ParseIP(egress.Source).To4()
+	if src == nil {
+		return nil, fmt.Errorf("没有找到发往 %s 的源地址", dest)
+	}
+	s.sources[dest.String()] = src
+	return src, nil
+}
+
+// send 构造并发送一个UDP探测包，IP ID 为 id
+func (s *rawSender) send(ttl int, dest *net.UDPAddr, id uint16, payload []byte) error {
+	src, err := s.source(dest.IP)
+	if err != nil {
+		return err
+	}
+	udp := buildUDP(src, dest.IP.To4(), s.srcPort, dest.Port, payload, s.checksum)
+	return s.sendIP(ttl, src, dest.IP, id, protocolUDP, udp)
+}
+
+// sendIP 给已经构造好的传输层报文加上IP头发送出去
+func (s *rawSender) sendIP(ttl int, src, dest net.IP, id uint16, protocol int, l4 []byte) error {
+	h := &ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TotalLen: ipv4.HeaderLen + len(l4),
+		ID:       int(id),
+		TTL:      ttl,
+		Protocol: protocol,
+		Src:      src,
+		Dst:      dest.To4(),
+	}
+	if s.df {
+		h.Flags = ipv4.DontFragment
+	}
+	// IP头的校验和由内核计算
+	return s.conn.WriteTo(h, l4, nil)
+}
+
+func (s *rawSender) Close() error { return s.conn.Close() }
+
+// buildUDP 构造UDP头，并按 mode 填写包含伪首部的校验和
+func buildUDP(src, dst net.IP, srcPort, dstPort int, payload []byte, mode string) []byte {
+	if mode == checksumConstant {
+		// 补偿用的2字节，先填 0 参与计算
+		payload = append(payload[:len(payload):len(payload)], 0, 0)
+	}
+	udp := make([]byte, udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(dstPort))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[udpHeaderLen:], payload)
+	if mode == checksumZero {
+		return udp
+	}
+
+	sum := pseudoChecksum(src, dst, protocolUDP, udp)
+	switch mode {
+	case checksumConstant:
+		// 反码和满足交换律，补偿值 x 加进去之后校验和变为 constantChecksum，
+		// 即 ^sum + x = ^constantChecksum，所以 x = ^constantChecksum - ^sum（反码减法）。
+		// 补偿字节从奇数偏移开始时，它在求和中的两个字节位置互换，写入时也要互换。
+		x := onesAdd(^uint16(constantChecksum), sum)
+		pad := udp[len(udp)-2:]
+		binary.BigEndian.PutUint16(pad, x)
+		if (12+len(udp)-2)%2 == 1 {
+			pad[0], pad[1] = pad[1], pad[0]
+		}
+		sum = constantChecksum
+	case checksumBad:
+		sum ^= 1
+	}
+	// 计算结果为 0 时按 RFC 768 发送全 1，0 表示“没有校验和”
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:8], sum)
+	return udp
+}
+
+// pseudoChecksum 计算UDP或TCP报文连同伪首部（源地址、目标地址、0、协议号、长度）的校验和
+func pseudoChecksum(src, dst net.IP, protocol int, seg []byte) uint16 {
+	pseudo := make([]byte, 0, 12+len(seg))
+	pseudo = append(pseudo, src.To4()...)
+	pseudo = append(pseudo, dst.To4()...)
+	pseudo = append(pseudo, 0, byte(protocol))
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(seg)))
+	pseudo = append(pseudo, seg...)
+	return icmpreply.Checksum(pseudo)
+}
+
+// onesAdd 是16位反码加法
+func onesAdd(a, b uint16) uint16 {
+	s := uint32(a) + uint32(b)
+	return uint16(s>>16 + s&0xffff)
+}
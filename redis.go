@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisDefaultPort 是 Redis 的默认端口
+const redisDefaultPort = "6379"
+
+// redisSink 在 trace 完成后把结果写进 Redis：
+// <prefix>:latest:<target> 总是保存这个目标最近一次的完整结果，仪表盘一次 GET 就能取到；
+// 指定了 stream 时再用 XADD 追加到这个 stream，供其他服务按顺序消费。
+type redisSink struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	prefix string
+	stream string
+	filter *hopFilter // 只写入满足 --filter 的跳
+}
+
+// newRedisSink 连接 rawURL 指定的 Redis，形如 redis://[[用户]:密码@]host[:port][/db]，
+// rediss:// 使用 TLS 连接
+func newRedisSink(rawURL, prefix, stream string, filter *hopFilter) (*redisSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 Redis 地址 %q: %w", rawURL, err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("无效的 Redis 地址 %q，应以 redis:// 或 rediss:// 开头", rawURL)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), redisDefaultPort)
+	}
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if u.Scheme == "rediss" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("连接 Redis %s 失败: %w", addr, err)
+	}
+	s := &redisSink{conn: conn, r: bufio.NewReader(conn), prefix: strings.TrimSuffix(prefix, ":"), stream: stream}
+
+	// 认证和选择数据库放在连接时完成，配置错误可以在探测开始之前就发现
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		args := []string{"AUTH", pass}
+		if name := u.User.Username(); name != "" {
+			args = []string{"AUTH", name, pass}
+		}
+		if _, err := s.do(args...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Redis 认证失败: %w", err)
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if _, err := strconv.Atoi(db); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("无效的 Redis 数据库编号 %q", db)
+		}
+		if _, err := s.do("SELECT", db); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("选择 Redis 数据库 %s 失败: %w", db, err)
+		}
+	}
+	return s, nil
+}
+
+// do 按 RESP 协议发送一条命令并读取回复，只处理简单字符串、错误、整数和批量字符串
+func (s *redisSink) do(args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := s.conn.Write([]byte(b.String())); err != nil {
+		return "", err
+	}
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("服务端返回了空回复")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", fmt.Errorf("服务端返回错误: %s", line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return "", err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(s.r, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+	return "", fmt.Errorf("无法识别的回复 %q", line)
+}
+
+func (s *redisSink) start(target, destIP string, egress *Egress) {}
+
+func (s *redisSink) hop(target string, hop *Hop) {}
+
+func (s *redisSink) complete(result *TraceResult) error {
+	result = anon.result(s.filter.result(result))
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("序列化结果失败: %w", err)
+	}
+	s.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := s.do("SET", s.prefix+":latest:"+result.Target, string(body)); err != nil {
+		return fmt.Errorf("写入 Redis 失败: %w", err)
+	}
+	if s.stream != "" {
+		if _, err := s.do("XADD", s.stream, "*", "target", result.Target, "trace_id", result.TraceID, "result", string(body)); err != nil {
+			return fmt.Errorf("写入 Redis stream %s 失败: %w", s.stream, err)
+		}
+	}
+	return nil
+}
+
+func (s *redisSink) Close() error { return s.conn.Close() }
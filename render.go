@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+)
+
+// outputJSON 把完整结果以缩进的 JSON 写到标准输出，只用于 render 子命令
+const outputJSON = "json"
+
+// writeResult 把结果以缩进的 JSON 格式写出，保存的文件之后可以用 render 子命令重新输出
+func writeResult(w io.Writer, result *TraceResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		return fmt.Errorf("序列化结果失败: %w", err)
+	}
+	return nil
+}
+
+// loadResult 读取 saveResult 或 savePB 保存的结果，path 为 "-" 时从标准输入读取，
+// gzip 压缩的内容会自动解压
+func loadResult(path string) (*TraceResult, error) {
+	var body []byte
+	var err error
+	if path == "-" {
+		body, err = io.ReadAll(os.Stdin)
+	} else {
+		body, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if body, err = decompress(path, body); err != nil {
+		return nil, err
+	}
+	var result TraceResult
+	if isJSON(body) {
+		err = json.Unmarshal(body, &result)
+	} else {
+		err = decodeResult(body, &result)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s 不是有效的 trace 结果: %w", path, err)
+	}
+	if err := checkSchemaVersion(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// runRender 实现 render 子命令：读取之前保存的结果，按指定的格式重新输出，
+// 这样测量和展示可以分开进行，例如在服务器上探测、回到本机再换格式查看。
+func runRender(args []string) {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	output := fs.String("output", outputTable, "输出格式：table、flat、compat、quiet、json、pb 或 html")
+	anonymize := fs.Bool("anonymize", false, "对输出中的地址做保持前缀的假名化（Crypto-PAn），并隐去主机名")
+	anonymizeKey := fs.String("anonymize-key", "", "--anonymize 使用的密钥，为空时使用随机密钥")
+	filterExpr := fs.String("filter", "", "只输出满足表达式的跳，例如 'rtt > 100ms || loss > 0'")
+	hostsFile := fs.String("hosts-file", "", "hosts(5) 格式的地址到主机名映射文件，host 列和 compat 格式先查它再查 DNS")
+	rttStatSpec := fs.String("rtt-stat", "", "按 mean、median 或 trim=K 重新计算每跳的平均 RTT，为空时沿用结果中记录的方法")
+	columnSpec := fs.String("columns", defaultColumns, "table 格式下逐跳输出的列及顺序，可选 ttl,ip,host,ipam,status,rtt,avg,loss,ports,asn,rpki,class,ixp,geo,ptr,ttls,tunnel")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: go run . render [选项] <结果文件|->\n")
+		fs.PrintDefaults()
+		envUsage(fs)
+	}
+	if flagsOnly(fs) {
+		return
+	}
+	fs.Parse(args)
+	// 命令行没有设置的选项，再从 TRACEROUTE_* 环境变量中读取
+	if err := applyEnv(fs); err != nil {
+		log.Fatalf("错误：%v", err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	switch *output {
+	case outputTable, outputFlat, outputCompat, outputQuiet, outputJSON, outputPB, outputHTML:
+	default:
+		log.Fatalf("错误：未知的输出格式 %q，可选 table、flat、compat、quiet、json、pb 或 html", *output)
+	}
+	cols, err := parseColumns(*columnSpec)
+	if err != nil {
+		log.Fatalf("错误：%v", err)
+	}
+	var filter *hopFilter
+	if *filterExpr != "" {
+		if filter, err = parseFilter(*filterExpr); err != nil {
+			log.Fatalf("错误：%v", err)
+		}
+	}
+	if *anonymize {
+		if anon, err = newAnonymizer(*anonymizeKey); err != nil {
+			log.Fatalf("错误：%v", err)
+		}
+	}
+	if *hostsFile != "" {
+		if localHosts, err = loadHostsFile(*hostsFile); err != nil {
+			log.Fatalf("错误：%v", err)
+		}
+	}
+	result, err := loadResult(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("错误：%v", err)
+	}
+	// 每个回应的 RTT 都保存在结果中，换一种估计方法可以直接重新计算
+	if *rttStatSpec != "" {
+		if rttStat, err = parseRTTStat(*rttStatSpec); err != nil {
+			log.Fatalf("错误：%v", err)
+		}
+		result.RTTStat = rttStat.String()
+		for i := range result.Hops {
+			applyRTTStat(&result.Hops[i])
+		}
+	} else if stat, err := parseRTTStat(result.RTTStat); err == nil {
+		rttStat = stat
+	}
+	if err := renderResult(result, traceOptions{Columns: cols, Output: *output, Filter: filter}); err != nil {
+		log.Fatalf("错误：%v", err)
+	}
+}
+
+// renderResult 把一个完整的结果按 opts.Output 指定的格式输出
+func renderResult(result *TraceResult, opts traceOptions) error {
+	switch opts.Output {
+	case outputJSON:
+		return writeResult(os.Stdout, anon.result(opts.Filter.result(result)))
+	case outputPB:
+		return writePB(os.Stdout, anon.result(opts.Filter.result(result)))
+	case outputHTML:
+		return writeHTML(os.Stdout, result, opts.Filter, !opts.NoDNS)
+	case outputQuiet:
+		fmt.Println(summarize(result))
+		return nil
+	}
+
+	switch opts.Output {
+	case outputTable:
+		fmt.Printf("traceroute 到 %s，开始于 %s\n",
+			describeTarget(result.Target, result.DestIP, result.Egress), result.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	case outputCompat:
+		fmt.Println(compatHeader(result.Target, result.DestIP))
+	}
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		if !opts.Filter.allows(hop) {
+			continue
+		}
+		printHop(result.Target, hop, opts)
+		if opts.Output == outputTable && hop.LoadBalancing != "" {
+			fmt.Printf("    %s\n", describeLoadBalancing(hop.LoadBalancing, hop.Responders))
+		}
+	}
+	if opts.Output == outputTable {
+		if result.Reached {
+			fmt.Println("Traceroute 完成!")
+		} else if n := len(result.Hops); n > 0 && result.Hops[n-1].State == hopUnreachable {
+			fmt.Println("目标不可达，探测结束")
+		}
+		if result.Loop != nil {
+			reportLoop(result.Loop)
+		}
+		reportDestPing(result)
+		reportGateway(result)
+		reportConnect(result)
+		reportHopPings(result)
+		reportTunnels(result)
+		reportAnnotations(result)
+		reportLateReplies(result)
+		reportRateLimiting(result)
+		// 保存的结果中不记录是否用过 --mtu、--pathchar，有测量值时才打印
+		if slices.ContainsFunc(result.Hops, func(h Hop) bool { return h.MTU > 0 }) {
+			reportMTUs(result, nil)
+		}
+		if slices.ContainsFunc(result.Hops, func(h Hop) bool { return h.Link != nil }) {
+			reportLinks(result)
+		}
+		reportRejected(result.Rejected)
+	}
+	return nil
+}
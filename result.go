@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Hop 记录某一跳（某个TTL值）的探测结果
+type Hop struct {
+	TTL   int       `json:"ttl"`                  // 本次探测使用的TTL值
+	Addr  string    `json:"addr,omitempty"`       // 返回ICMP消息的主机地址，超时则为空
+	Host  string    `json:"host,omitempty"`       // 地址反向解析出的主机名（--columns 中含 host 或 --ptr-check 时）
+	Class string    `json:"addr_class,omitempty"` // 特殊地址段分类：private、cgnat、link-local、loopback 或 bogon
+	IXP   string    `json:"ixp,omitempty"`        // 地址所在的 IXP 交换网段的名字（--ixp-file）
+	IPAM  *IPAMInfo `json:"ipam,omitempty"`       // 地址所在前缀在 IPAM 导出中的描述（--ipam-file）
+	Geo   *GeoInfo  `json:"geo,omitempty"`        // --geo-file 中的地理位置和光速一致性检查结果
+
+	PTRMismatch bool `json:"ptr_mismatch,omitempty"` // 主机名正向解析不到这一跳的地址，PTR 记录可能已经过时（--ptr-check）
+
+	RTTMs    float64  `json:"rtt_ms,omitempty"`       // 第一个回应的往返时延，单位毫秒
+	State    string   `json:"state"`                  // 这一跳的状态，取值见下面的 hop* 常量
+	ICMPType int      `json:"icmp_type,omitempty"`    // 收到的ICMP消息类型
+	ICMPCode int      `json:"icmp_code,omitempty"`    // 收到的ICMP消息代码
+	Timeout  bool     `json:"timeout,omitempty"`      // 这一跳是否超时未响应
+	MTU      int      `json:"mtu,omitempty"`          // 能够到达这一跳的最大IP包长度（--mtu）
+	NextMTU  int      `json:"next_hop_mtu,omitempty"` // 这一跳在“需要分片”消息中报告的下一跳 MTU
+	Labels   []string `json:"labels,omitempty"`       // 外部程序给出的标注（--annotate-cmd）
+	Protocol string   `json:"protocol,omitempty"`     // 探测这一跳最终使用的协议：udp、icmp 或 tcp（--fallback）
+	Ports    []int    `json:"ports,omitempty"`        // 得到回应的目标端口（--ports）
+
+	ASN        int    `json:"asn,omitempty"`         // 地址所在前缀的起源 AS 号（--asn）
+	ASBoundary bool   `json:"as_boundary,omitempty"` // 这一跳与上一个已知 AS 的跳属于不同的 AS
+	Prefix     string `json:"prefix,omitempty"`      // 起源 AS 宣告的、覆盖该地址的前缀
+	RPKI       string `json:"rpki,omitempty"`        // 该宣告的 RPKI 起源验证结果：valid、invalid 或 not-found（--rpki-vrps）
+
+	Sent        int           `json:"sent"`                   // 发送的探测包数量
+	Received    int           `json:"received"`               // 收到回应的数量
+	LossPct     float64       `json:"loss_pct"`               // 丢包率（百分比）
+	RTTsMs      []float64     `json:"rtts_ms,omitempty"`      // 每个回应的往返时延
+	RTTAvgMs    float64       `json:"rtt_avg_ms,omitempty"`   // 按 --rtt-stat 计算的平均往返时延
+	RTTDropped  int           `json:"rtt_dropped,omitempty"`  // 计算平均值时当作离群值去掉的回应数量
+	RateLimited bool          `json:"rate_limited,omitempty"` // 丢包来自ICMP限速，而不是真实的转发丢包
+	Late        int           `json:"late,omitempty"`         // 超时之后才到达的回应数量
+	Duplicates  int           `json:"duplicates,omitempty"`   // 重复到达的回应数量
+	Probes      []ProbeRecord `json:"probes,omitempty"`       // 按发送顺序排列的每个探测包的结果
+
+	LoadBalancing string   `json:"load_balancing,omitempty"` // 负载均衡分类：per-flow、per-packet 或 route-unstable
+	Responders    []string `json:"responders,omitempty"`     // 分类时在这一跳观察到的所有响应者
+
+	Link *LinkEstimate `json:"link,omitempty"` // 上一跳到这一跳之间链路的带宽和时延估计（--pathchar）
+	Ping *HopPing      `json:"ping,omitempty"` // 路径探测完成后直接 ping 这一跳的结果（--hop-ping）
+
+	ReplyTTL   int         `json:"reply_ttl,omitempty"`   // 第一个回应到达本机时IP头中剩余的TTL
+	QuotedTTL  int         `json:"quoted_ttl,omitempty"`  // 第一个回应引用的探测包IP头中的TTL
+	HiddenHops int         `json:"hidden_hops,omitempty"` // 推断的、上一个回应者和这一跳之间被隐藏的跳数（--tunnels）
+	MPLS       []MPLSLabel `json:"mpls,omitempty"`        // 第一个回应携带的 RFC 4950 MPLS 标签栈
+	Tunnel     string      `json:"tunnel,omitempty"`      // 推断的隧道类型：explicit、implicit、opaque 或 invisible（--tunnels）
+}
+
+// ProbeRecord 记录一个探测包的结果。同一跳的探测包可能由不同的路由器回应
+// （例如存在负载均衡时），因此地址按探测包分别记录。
+type ProbeRecord struct {
+	Seq      int     `json:"seq,omitempty"`       // 探测包的序号，与 trace_id、TTL 一起唯一地标识这个探测包
+	Port     int     `json:"port,omitempty"`      // 探测包的目标端口
+	Addr     string  `json:"addr,omitempty"`      // 回应者地址
+	RTTMs    float64 `json:"rtt_ms,omitempty"`    // 往返时延，单位毫秒
+	ICMPType int     `json:"icmp_type,omitempty"` // 回应的ICMP类型
+	ICMPCode int     `json:"icmp_code,omitempty"` // 回应的ICMP代码
+	Timeout  bool    `json:"timeout,omitempty"`   // 是否超时未收到回应
+
+	// 发送和收到回应时的系统时间，纳秒精度，用于和日志、抓包文件对照。
+	// RTT 以 rtt_ns 为准：它用单调时钟计算，两个时间戳之差在系统时间被调整时可能不准。
+	SentAt     time.Time `json:"sent_at,omitzero"`
+	ReceivedAt time.Time `json:"received_at,omitzero"`
+	RTTNs      int64     `json:"rtt_ns,omitempty"` // 往返时延，单位纳秒
+
+	Iface string `json:"iface,omitempty"` // 收到回应的接口（--af-packet）
+
+	ReplyTTL  int `json:"reply_ttl,omitempty"`  // 回应到达本机时IP头中剩余的TTL
+	QuotedTTL int `json:"quoted_ttl,omitempty"` // 回应引用的探测包IP头中的TTL
+}
+
+// TraceResult 是一次完整 traceroute 的结构化结果，
+// 既用于终端之外的输出（例如 webhook），也方便后续扩展其他格式。
+type TraceResult struct {
+	SchemaVersion int    `json:"schema_version"`     // 结果格式的版本号，兼容规则见 resultSchemaVersion
+	TraceID       string `json:"trace_id,omitempty"` // 本次 trace 的标识（8位十六进制），也写在每个探测包的负载中；早于它的 v1 结果中没有
+
+	Target      string    `json:"target"`                 // 用户输入的目标
+	TargetASCII string    `json:"target_ascii,omitempty"` // 目标是国际化域名时它的 punycode 形式，也就是实际查询 DNS 的名字
+	DestIP      string    `json:"dest_ip"`                // 目标解析出的IP地址
+	Egress      *Egress   `json:"egress,omitempty"`       // 本机的出口信息
+	Reached     bool      `json:"reached"`                // 是否到达了目标主机
+	StartedAt   time.Time `json:"started_at"`             // 开始探测的时间
+	Hops        []Hop     `json:"hops"`                   // 按TTL顺序排列的每一跳结果
+
+	PathLength int            `json:"path_length,omitempty"` // 二分查找估计的路径长度（--bisect）
+	RTTStat    string         `json:"rtt_stat,omitempty"`    // 每跳平均 RTT 的估计方法：mean、median 或 trim=K（--rtt-stat）
+	Connect    *ConnectTiming `json:"tcp_connect,omitempty"` // 路径探测完成后到目标的 TCP 连接耗时（--tcp-connect）
+	DestPing   *DestPing      `json:"dest_ping,omitempty"`   // 路径探测期间并行 ping 目标的统计（--dest-ping）
+	Reverse    *TraceResult   `json:"reverse,omitempty"`     // 对端向本机探测得到的反向路径（--reverse-peer）
+	Loop       []string       `json:"loop,omitempty"`        // 检测到的转发环路中的地址，没有环路时为空
+
+	LateReplies []ReplyEvent   `json:"late_replies,omitempty"` // 所有迟到和重复的回应
+	Rejected    map[string]int `json:"rejected,omitempty"`     // 按原因统计的被丢弃的ICMP消息
+	Errors      []TraceError   `json:"errors,omitempty"`       // 没有中止探测的错误
+}
+
+// 结构化错误的代码
+const (
+	errEgressLookup = "egress_lookup_failed" // 查询出口路由失败，结果中没有出口信息
+	errProbe        = "probe_failed"         // 发送探测包或读取回应失败，该探测包按超时记录
+	errReverse      = "reverse_failed"       // --reverse-peer 的反向探测失败
+	errAnnotate     = "annotate_failed"      // --annotate-cmd 的标注程序失败
+	errGatewayMAC   = "gateway_mac_failed"   // --gateway-mac 没有查到网关的 MAC 地址
+	errTraceFailed  = "trace_failed"         // pipe 子命令中探测这个目标的 trace 进程失败，结果中只有目标和错误
+)
+
+// TraceError 是一个没有中止探测、但让结果不完整的错误。
+// 除了照常打印到标准错误，也写进结果，让使用 JSON 的程序不必解析日志。
+type TraceError struct {
+	Code      string `json:"code"`          // 错误代码，取值见上面的 err* 常量
+	Message   string `json:"message"`       // 错误的详细说明
+	TTL       int    `json:"ttl,omitempty"` // 出错的跳，与具体某一跳无关时为 0
+	Retryable bool   `json:"retryable"`     // 重新运行是否有可能成功
+}
+
+// addError 打印一个错误并把它记进结果
+func (r *TraceResult) addError(code string, ttl int, retryable bool, err error) {
+	log.Printf("%v\n", err)
+	r.Errors = append(r.Errors, TraceError{Code: code, Message: err.Error(), TTL: ttl, Retryable: retryable})
+}
+
+// 每一跳的状态。使用结果的程序只需要看 state，不必再从ICMP类型和代码推断含义。
+const (
+	hopResponded   = "responded"    // 中间路由器返回了 Time Exceeded
+	hopReached     = "reached"      // 目标返回了端口不可达（或 Echo Reply、TCP 回应），探测到达终点
+	hopUnreachable = "unreachable"  // 返回了其他代码的目标不可达（!N、!H、!X 等），代码见 icmp_code
+	hopTimeout     = "timeout"      // 所有探测包都没有收到回应
+	hopRateLimited = "rate-limited" // 有回应，但这一跳的丢包来自ICMP限速
+	hopLoop        = "loop"         // 这一跳属于检测到的转发环路
+	hopOther       = "other"        // 收到了其他类型的ICMP消息
+)
+
+// hopState 根据回应的ICMP类型和代码得出一跳的状态
+func hopState(t ipv4.ICMPType, code int) string {
+	switch {
+	case t == ipv4.ICMPTypeTimeExceeded:
+		return hopResponded
+	case t == ipv4.ICMPTypeDestinationUnreachable && code == codePortUnreachable:
+		return hopReached
+	case t == ipv4.ICMPTypeDestinationUnreachable:
+		return hopUnreachable
+	}
+	return hopOther
+}
+
+// 迟到/重复回应的种类
+const (
+	replyLate      = "late"      // 探测包已被判定为超时之后才收到的回应
+	replyDuplicate = "duplicate" // 同一个探测包收到的第二个及以后的回应
+)
+
+// ReplyEvent 记录一个没有被计入正常结果的回应，
+// 以免它被错误地归到其他跳上，同时保留它的RTT供分析
+type ReplyEvent struct {
+	Kind  string  `json:"kind"`   // late 或 duplicate
+	TTL   int     `json:"ttl"`    // 该回应对应的探测包的TTL
+	Addr  string  `json:"addr"`   // 发出回应的主机
+	RTTMs float64 `json:"rtt_ms"` // 从发送对应探测包到收到该回应的时间
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reversePath 是 peer 子命令提供反向探测的HTTP路径
+const reversePath = "/reverse"
+
+// runPeer 实现 peer 子命令：在路径的另一端运行，收到请求后向请求方做一次 traceroute，
+// 把结果以 JSON 返回。只会探测发起请求的地址，不接受任意目标，
+// 因此把它暴露在网络上不会变成替别人探测第三方的工具。
+func runPeer(args []string) {
+	fs := flag.NewFlagSet("peer", flag.ExitOnError)
+	listen := fs.String("listen", ":33435", "接受反向探测请求的HTTP监听地址")
+	probes := fs.Int("probes", 1, "每一跳发送的探测包数量")
+	cacheTTL := fs.Duration("cache-ttl", time.Minute, "同一个地址在这段时间内重复请求时直接返回上一次的结果，为 0 则每次都重新探测")
+	runAsUser := fs.String("user", "nobody", "以 root 运行时，创建原始套接字后切换到的非特权用户，为空则不切换")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: sudo go run . peer [选项]\n")
+		fs.PrintDefaults()
+		envUsage(fs)
+	}
+	if flagsOnly(fs) {
+		return
+	}
+	fs.Parse(args)
+	// 命令行没有设置的选项，再从 TRACEROUTE_* 环境变量中读取
+	if err := applyEnv(fs); err != nil {
+		log.Fatalf("错误：%v", err)
+	}
+	if *probes < 1 {
+		log.Fatalf("错误：--probes 至少为 1")
+	}
+	if *cacheTTL < 0 {
+		log.Fatalf("错误：--cache-ttl 不能为负数")
+	}
+
+	cfg := probeConfig{TraceID: newTraceID()}
+	p, err := openProber(cfg)
+	if err != nil {
+		log.Fatalf("错误：%v", err)
+	}
+	defer p.Close()
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("错误：%v", err)
+	}
+	if err := dropPrivileges(*runAsUser); err != nil {
+		log.Fatalf("错误：放弃 root 权限失败: %v（可以使用 --user= 禁用此功能）", err)
+	}
+	fmt.Printf("等待反向探测请求，监听 %s\n", ln.Addr())
+
+	// 同一个 prober 不能同时用于两次探测，请求逐个处理。
+	// 探测的选项对所有请求都一样，所以结果按请求方地址缓存即可，
+	// 避免脚本频繁调用时把同一条路径反复探测几十遍。
+	var mu sync.Mutex
+	cache := make(map[string]cachedTrace)
+	http.HandleFunc(reversePath, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "只支持 POST", http.StatusMethodNotAllowed)
+			return
+		}
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		destIP := net.ParseIP(host).To4()
+		if err != nil || destIP == nil {
+			http.Error(w, "只能向IPv4地址发起反向探测", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		for addr, c := range cache {
+			if now.Sub(c.at) >= *cacheTTL {
+				delete(cache, addr)
+			}
+		}
+		if c, ok := cache[destIP.String()]; ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Age", strconv.Itoa(int(now.Sub(c.at).Seconds())))
+			json.NewEncoder(w).Encode(c.result)
+			return
+		}
+
+		fmt.Printf("向 %s 发起反向探测\n", destIP)
+		egress, egressErr := lookupEgress(destIP, cfg)
+		if egressErr != nil {
+			log.Printf("查询出口路由失败: %v\n", egressErr)
+		}
+		if r, ok := p.(resetter); ok {
+			r.reset()
+		}
+		result := runTrace(p, destIP.String(), destIP, egress, traceOptions{Probes: *probes, Output: outputQuiet, TraceID: cfg.TraceID})
+		// 缓存的结果不能和 prober 共用统计，否则之后的探测还会改动它
+		result.Rejected = maps.Clone(p.rejected())
+		if egressErr != nil {
+			result.Errors = append(result.Errors, TraceError{Code: errEgressLookup, Message: egressErr.Error()})
+		}
+		if *cacheTTL > 0 {
+			cache[destIP.String()] = cachedTrace{result: result, at: now}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+	log.Fatal(http.Serve(ln, nil))
+}
+
+// cachedTrace 是 peer 缓存的一次反向探测结果
+type cachedTrace struct {
+	result *TraceResult
+	at     time.Time // 探测开始的时间
+}
+
+// reverseTrace 请求路径另一端的 peer 向本机做 traceroute。
+// 它和本机的正向探测同时进行，探测结束后从返回的通道中取得结果。
+func reverseTrace(peerURL string, probes int) <-chan reverseResult {
+	ch := make(chan reverseResult, 1)
+	go func() {
+		// 对端最多要探测 maxHops 跳，每跳最多等待 probes 个超时
+		client := &http.Client{Timeout: time.Duration(maxHops*probes)*timeout + 30*time.Second}
+		resp, err := client.Post(strings.TrimSuffix(peerURL, "/")+reversePath, "application/json", nil)
+		if err != nil {
+			ch <- reverseResult{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			ch <- reverseResult{err: fmt.Errorf("对端返回 %s", resp.Status)}
+			return
+		}
+		var result TraceResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			ch <- reverseResult{err: fmt.Errorf("解析对端结果失败: %w", err)}
+			return
+		}
+		ch <- reverseResult{result: &result}
+	}()
+	return ch
+}
+
+// reverseResult 是 reverseTrace 的结果
+type reverseResult struct {
+	result *TraceResult
+	err    error
+}
+
+// reportReverse 打印反向路径，并和正向路径对比。
+// 路由器通常用收到探测包的那个接口的地址回应，同一台路由器在两个方向上
+// 出现的地址往往不同，因此两个方向跳数不同才是路径不对称的可靠信号，
+// 共同出现的地址只作为参考。
+func reportReverse(forward, reverse *TraceResult, opts traceOptions) {
+	if opts.Output != outputTable {
+		return
+	}
+	fmt.Printf("反向路径（从对端 %s 到本机 %s）:\n", describeEgressSource(reverse.Egress), anon.addr(reverse.DestIP))
+	for i := range reverse.Hops {
+		printHop(reverse.Target, &reverse.Hops[i], opts)
+	}
+	if !reverse.Reached {
+		fmt.Printf("反向探测在 %d 跳内没有到达本机\n", maxHops)
+	}
+
+	seen := make(map[string]bool)
+	for _, hop := range forward.Hops {
+		if hop.Addr != "" {
+			seen[hop.Addr] = true
+		}
+	}
+	var common []string
+	for _, hop := range reverse.Hops {
+		if hop.Addr != "" && seen[hop.Addr] {
+			common = append(common, hop.Addr)
+		}
+	}
+	fmt.Printf("正向 %d 跳，反向 %d 跳，两个方向共同出现的地址 %d 个", len(forward.Hops), len(reverse.Hops), len(common))
+	if forward.Reached && reverse.Reached && len(forward.Hops) != len(reverse.Hops) {
+		fmt.Print("：两个方向的跳数不同，路由不对称")
+	}
+	fmt.Println()
+}
+
+// describeEgressSource 返回对端出口的源地址
+func describeEgressSource(e *Egress) string {
+	if e == nil || e.Source == "" {
+		return "未知地址"
+	}
+	return anon.addr(e.Source)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Egress 描述操作系统为到达目标所选择的出口：源地址、出接口和下一跳网关。
+// 在多出口的机器上，它告诉用户本次测量的到底是哪一条路径。
+type Egress struct {
+	Source    string `json:"source,omitempty"`    // 探测包使用的源IP地址
+	Interface string `json:"interface,omitempty"` // 出接口名
+	Gateway   string `json:"gateway,omitempty"`   // 下一跳网关，目标直连时为空
+
+	GatewayMAC    string `json:"gateway_mac,omitempty"`    // 网关的 MAC 地址（--gateway-mac）
+	GatewayVendor string `json:"gateway_vendor,omitempty"` // 根据 OUI 得到的厂商，或者 VRRP 等虚拟 MAC 的说明
+}
+
+// String 把出口信息格式化成附加在目标行后面的说明
+func (e *Egress) String() string {
+	var parts []string
+	if e.Source != "" {
+		parts = append(parts, "源地址 "+anon.addr(e.Source))
+	}
+	if e.Interface != "" {
+		parts = append(parts, "出接口 "+e.Interface)
+	}
+	if e.Gateway != "" {
+		parts = append(parts, "网关 "+anon.addr(e.Gateway))
+	} else if e.Interface != "" {
+		parts = append(parts, "直连")
+	}
+	return strings.Join(parts, "，")
+}
+
+// describeTarget 生成 "开始 traceroute" 那一行中目标及其出口信息的部分
+func describeTarget(target, destIP string, egress *Egress) string {
+	s := fmt.Sprintf("%s (%s)", describeName(target), anon.addr(destIP))
+	if egress != nil {
+		if detail := egress.String(); detail != "" {
+			s += "，" + detail
+		}
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// lookupEgress 通过 netlink 向内核发送 RTM_GETROUTE 请求（相当于 `ip route get`），
+// 查询到达 destIP 的路由。防火墙标记和 VRF 也会带进请求里，
+// 保证查到的正是探测包实际会走的那条路由。
+func lookupEgress(destIP net.IP, cfg probeConfig) (*Egress, error) {
+	dst := destIP.To4()
+	if dst == nil {
+		return nil, fmt.Errorf("%s 不是IPv4地址", destIP)
+	}
+
+	// 构造请求：nlmsghdr + rtmsg + 若干 rtattr
+	req := make([]byte, unix.SizeofNlMsghdr+unix.SizeofRtMsg)
+	rtm := req[unix.SizeofNlMsghdr:]
+	rtm[0] = unix.AF_INET // rtm_family
+	rtm[1] = 32           // rtm_dst_len
+	req = appendRtAttr(req, unix.RTA_DST, dst)
+	if cfg.Mark != 0 {
+		req = appendRtAttr(req, unix.RTA_MARK, binary.NativeEndian.AppendUint32(nil, cfg.Mark))
+	}
+	if cfg.VRF != "" {
+		ifi, err := net.InterfaceByName(cfg.VRF)
+		if err != nil {
+			return nil, err
+		}
+		req = appendRtAttr(req, unix.RTA_OIF, binary.NativeEndian.AppendUint32(nil, uint32(ifi.Index)))
+	}
+	binary.NativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:6], unix.RTM_GETROUTE)
+	binary.NativeEndian.PutUint16(req[6:8], unix.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(req[8:12], 1) // 序号
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("创建 netlink 套接字失败: %w", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("发送路由查询失败: %w", err)
+	}
+	buf := make([]byte, 4096)
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("读取路由查询结果失败: %w", err)
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return nil, fmt.Errorf("解析 netlink 消息失败: %w", err)
+	}
+	for _, m := range msgs {
+		switch m.Header.Type {
+		case unix.NLMSG_ERROR:
+			// 错误消息的前4个字节是负的 errno，例如没有路由时为 -ENETUNREACH
+			if len(m.Data) >= 4 {
+				if errno := -int32(binary.NativeEndian.Uint32(m.Data[:4])); errno != 0 {
+					return nil, fmt.Errorf("路由查询失败: %w", syscall.Errno(errno))
+				}
+			}
+		case unix.RTM_NEWROUTE:
+			return parseRouteAttrs(&m)
+		}
+	}
+	return nil, fmt.Errorf("内核没有返回路由信息")
+}
+
+// appendRtAttr 在请求末尾追加一个 rtattr，并按4字节对齐
+func appendRtAttr(b []byte, typ uint16, data []byte) []byte {
+	attrLen := unix.SizeofRtAttr + len(data)
+	b = binary.NativeEndian.AppendUint16(b, uint16(attrLen))
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	b = append(b, data...)
+	for len(b)%unix.NLMSG_ALIGNTO != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// parseRouteAttrs 从 RTM_NEWROUTE 消息中取出首选源地址、出接口和网关
+func parseRouteAttrs(m *syscall.NetlinkMessage) (*Egress, error) {
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return nil, fmt.Errorf("解析路由属性失败: %w", err)
+	}
+	egress := &Egress{}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case unix.RTA_PREFSRC:
+			egress.Source = net.IP(a.Value).String()
+		case unix.RTA_GATEWAY:
+			egress.Gateway = net.IP(a.Value).String()
+		case unix.RTA_OIF:
+			if len(a.Value) < 4 {
+				continue
+			}
+			index := int(binary.NativeEndian.Uint32(a.Value))
+			if ifi, err := net.InterfaceByIndex(index); err == nil {
+				egress.Interface = ifi.Name
+			}
+		}
+	}
+	return egress, nil
+}
@@ -0,0 +1,37 @@
+//go:build !linux
+
+package main
+
+import (
+	"net"
+)
+
+// lookupEgress 在没有 netlink 的平台上借助“连接”一个UDP套接字让内核选路，
+// 从而得到源地址，再根据源地址找到对应的接口。网关在这些平台上无法获取。
+func lookupEgress(destIP net.IP, cfg probeConfig) (*Egress, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: destIP, Port: destPort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	src := conn.LocalAddr().(*net.UDPAddr).IP
+	egress := &Egress{Source: src.String()}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return egress, nil
+	}
+	for _, ifi := range ifaces {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(src) {
+				egress.Interface = ifi.Name
+				return egress, nil
+			}
+		}
+	}
+	return egress, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3DefaultKey 是 --s3-key 的默认值，按日期和目标分目录，便于按前缀列出和设置生命周期规则
+const s3DefaultKey = "traceroute/{date}/{target}/{time}-{trace_id}.ndjson.gz"
+
+// s3Sink 在 trace 完成后把结果压缩成 gzip 的 NDJSON 上传到 S3 兼容的对象存储，
+// 用于边缘探测点的长期归档。每次运行上传一个对象，对象中的每一行是一个完整结果，
+// 和 --output json=... 的格式相同，解压之后可以直接交给 render 子命令。
+//
+// 请求按 AWS Signature Version 4 签名，凭据从标准的 AWS_ACCESS_KEY_ID、
+// AWS_SECRET_ACCESS_KEY 和 AWS_SESSION_TOKEN 环境变量读取，MinIO、Ceph 等也使用同样的签名方式。
+type s3Sink struct {
+	client            *http.Client
+	bucket            *url.URL // 存储桶的地址，路径风格或虚拟主机风格都可以
+	key               string   // 对象名模板
+	region            string
+	accessKey, secret string
+	sessionToken      string
+	filter            *hopFilter // 只上传满足 --filter 的跳
+}
+
+// newS3Sink 检查存储桶地址和凭据，bucketURL 形如 https://s3.us-east-1.amazonaws.com/bucket
+// 或 https://bucket.s3.amazonaws.com 或 http://minio:9000/bucket
+func newS3Sink(bucketURL, key, region string, filter *hopFilter) (*s3Sink, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("无效的存储桶地址 %q，应以 http:// 或 https:// 开头", bucketURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	s := &s3Sink{
+		client:       &http.Client{Timeout: time.Minute},
+		bucket:       u,
+		key:          key,
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret:       os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_REGION")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.accessKey == "" || s.secret == "" {
+		return nil, fmt.Errorf("上传到 S3 需要设置 AWS_ACCESS_KEY_ID 和 AWS_SECRET_ACCESS_KEY 环境变量")
+	}
+	return s, nil
+}
+
+// objectKey 展开对象名模板中的占位符：{target}、{trace_id}、{date}（2006-01-02）、
+// {time}（150405）以及 {year}、{month}、{day}、{hour}，时间使用 UTC
+func (s *s3Sink) objectKey(result *TraceResult) string {
+	t := result.StartedAt.UTC()
+	return strings.NewReplacer(
+		"{target}", result.Target,
+		"{trace_id}", result.TraceID,
+		"{date}", t.Format(time.DateOnly),
+		"{time}", t.Format("150405"),
+		"{year}", t.Format("2006"),
+		"{month}", t.Format("01"),
+		"{day}", t.Format("02"),
+		"{hour}", t.Format("15"),
+	).Replace(strings.TrimPrefix(s.key, "/"))
+}
+
+func (s *s3Sink) start(target, destIP string, egress *Egress) {}
+
+func (s *s3Sink) hop(target string, hop *Hop) {}
+
+func (s *s3Sink) complete(result *TraceResult) error {
+	result = anon.result(s.filter.result(result))
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if err := json.NewEncoder(zw).Encode(result); err != nil {
+		return fmt.Errorf("序列化结果失败: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	key := s.objectKey(result)
+	if err := s.put(key, body.Bytes()); err != nil {
+		return fmt.Errorf("上传 %s 失败: %w", key, err)
+	}
+	return nil
+}
+
+// put 用签名过的 PUT 请求上传一个对象
+func (s *s3Sink) put(key string, body []byte) error {
+	u := *s.bucket
+	u.RawPath = s3EscapePath(u.Path + "/" + key)
+	u.Path = u.Path + "/" + key
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	// 对象本身就是 .gz 文件，不能标成 Content-Encoding: gzip，
+	// 否则下载时客户端会自动解压，拿到的内容与对象名不符
+	req.Header.Set("Content-Type", "application/gzip")
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("服务端返回 %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sign 按 AWS Signature Version 4 给请求加上 Authorization 头，
+// 签名覆盖 host、x-amz-content-sha256、x-amz-date 以及可能有的 x-amz-security-token
+func (s *s3Sink) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	// 规范请求中的头部按名字排序，值去掉首尾空白
+	headers := []string{"host:" + req.URL.Host, "x-amz-content-sha256:" + payloadHash, "x-amz-date:" + amzDate}
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	if s.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token:"+s.sessionToken)
+		signed += ";x-amz-security-token"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		strings.Join(headers, "\n") + "\n",
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	key := hmacSHA256([]byte("AWS4"+s.secret), day)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signed, signature))
+}
+
+// s3CanonicalQuery 按 SigV4 的要求排列查询参数：按名字和值排序，
+// 没有值的参数写成 name=，名字和值中的斜杠也要编码
+func s3CanonicalQuery(q url.Values) string {
+	var pairs []string
+	for name, values := range q {
+		for _, v := range values {
+			pairs = append(pairs, s3Escape(name, false)+"="+s3Escape(v, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// s3EscapePath 按 SigV4 的要求编码对象路径：除字母、数字和 -_.~ 之外的字节都写成 %XX，斜杠保留
+func s3EscapePath(p string) string {
+	return s3Escape(p, true)
+}
+
+func s3Escape(p string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+func (s *s3Sink) Close() error { return nil }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// resultSchemaVersion 是 TraceResult JSON 格式的版本号，写在结果的 schema_version 字段中。
+//
+// 兼容规则：
+//   - 新增字段不改变版本号。新字段都是可选的，解析时应当忽略不认识的字段。
+//   - 已有字段不会删除，也不会改变名字、类型和含义。
+//   - 只有无法遵守以上两条的修改才会增加版本号，程序拒绝读取比自己新的版本。
+//
+// 没有 schema_version 的结果是加入这个字段之前保存的，按版本 1 处理。
+const resultSchemaVersion = 1
+
+// checkSchemaVersion 确认结果的格式版本是本程序能够理解的，
+// 并把没有版本号的旧结果标为版本 1
+func checkSchemaVersion(r *TraceResult) error {
+	if r.SchemaVersion == 0 {
+		r.SchemaVersion = 1
+	}
+	if r.SchemaVersion > resultSchemaVersion {
+		return fmt.Errorf("结果的格式版本 %d 比本程序支持的 %d 新，请升级后再读取", r.SchemaVersion, resultSchemaVersion)
+	}
+	return nil
+}
+
+// runSchema 实现 schema 子命令：打印由 TraceResult 的 Go 类型生成的 JSON Schema，
+// 下游程序可以用它校验 --save、webhook 和 render --output json 的输出
+func runSchema(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: go run . schema\n")
+	}
+	if flagsOnly(fs) {
+		return
+	}
+	fs.Parse(args)
+	// 命令行没有设置的选项，再从 TRACEROUTE_* 环境变量中读取
+	if err := applyEnv(fs); err != nil {
+		log.Fatalf("错误：%v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(resultSchema())
+}
+
+// resultSchema 生成 TraceResult 的 JSON Schema（draft 2020-12）。
+// 每个结构体类型放在 $defs 中，用 $ref 引用，这样 Reverse 这样的递归字段也能表示。
+func resultSchema() map[string]any {
+	defs := make(map[string]any)
+	root := schemaFor(reflect.TypeOf(TraceResult{}), defs)
+	return map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     fmt.Sprintf("urn:udp-traceroute:result:v%d", resultSchemaVersion),
+		"title":   "udp-traceroute 结果",
+		"$ref":    root["$ref"],
+		"$defs":   defs,
+	}
+}
+
+// schemaFor 返回类型 t 对应的 schema，结构体会被加入 defs
+func schemaFor(t reflect.Type, defs map[string]any) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), defs)}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/$defs/" + t.Name()}
+		if _, ok := defs[t.Name()]; ok {
+			return ref
+		}
+		// 先占位，防止递归类型无限展开
+		defs[t.Name()] = nil
+		props := make(map[string]any)
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if !f.IsExported() || tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaFor(f.Type, defs)
+			if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+				required = append(required, name)
+			}
+		}
+		def := map[string]any{"type": "object", "properties": props}
+		if required != nil {
+			def["required"] = required
+		}
+		defs[t.Name()] = def
+		return ref
+	}
+	return map[string]any{}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// outputSink 接收一次 trace 的过程和结果。终端上的输出和写入文件的输出都实现这个接口，
+// 同一次 trace 可以同时写到多个 sink 中，例如终端显示表格、同时把 JSON 保存到文件。
+type outputSink interface {
+	// start 在开始探测之前调用
+	start(target, destIP string, egress *Egress)
+	// hop 在每一跳探测完成时调用
+	hop(target string, hop *Hop)
+	// complete 在所有测量（限速检测、MTU 等）完成之后调用，result 是最终结果
+	complete(result *TraceResult) error
+	Close() error
+}
+
+// terminalSink 按 table、flat、compat 或 quiet 格式把结果打印到标准输出
+type terminalSink struct {
+	opts traceOptions
+}
+
+func (t *terminalSink) start(target, destIP string, egress *Egress) {
+	// flat 模式的标准输出只留给逐跳的 key=value 行
+	switch t.opts.Output {
+	case outputTable:
+		fmt.Printf("开始 traceroute 到 %s\n", describeTarget(target, destIP, egress))
+	case outputCompat:
+		fmt.Println(compatHeader(target, destIP))
+	}
+}
+
+func (t *terminalSink) hop(target string, hop *Hop) { printHop(target, hop, t.opts) }
+
+func (t *terminalSink) complete(result *TraceResult) error {
+	switch t.opts.Output {
+	case outputQuiet:
+		fmt.Println(summarize(result))
+	case outputFlat:
+		writeFlatMeasured(os.Stdout, result, t.opts.Filter)
+	case outputTable:
+		reportRejected(result.Rejected)
+	}
+	return nil
+}
+
+func (t *terminalSink) Close() error { return nil }
+
+// fileSink 把结果以 json、pb、flat、compat 或 html 格式写进文件。
+// flat 和 compat 边探测边写，json、pb 和 html 在最后一次性写入完整结果。
+// 文件都在创建 sink 时打开：这时还没有放弃 root 权限，之后就未必能在当前目录创建文件了。
+type fileSink struct {
+	format  string
+	resolve bool           // compat 和 html 格式是否反向解析主机名
+	filter  *hopFilter     // json、pb 和 html 格式只保存满足 --filter 的跳
+	w       io.WriteCloser // 打开的文件，文件名以 .gz 结尾时经过 gzip 压缩
+}
+
+func newFileSink(o fileOutput, resolve bool, filter *hopFilter) (*fileSink, error) {
+	s := &fileSink{format: o.format, resolve: resolve, filter: filter}
+	w, err := createOutput(o.path)
+	if err != nil {
+		return nil, err
+	}
+	s.w = w
+	return s, nil
+}
+
+func (s *fileSink) start(target, destIP string, egress *Egress) {
+	if s.format == outputCompat {
+		fmt.Fprintln(s.w, compatHeader(target, destIP))
+	}
+}
+
+func (s *fileSink) hop(target string, hop *Hop) {
+	switch s.format {
+	case outputFlat:
+		fmt.Fprintln(s.w, formatFlat(target, hop))
+	case outputCompat:
+		fmt.Fprintln(s.w, formatCompat(hop, s.resolve))
+	}
+}
+
+func (s *fileSink) complete(result *TraceResult) error {
+	var err error
+	switch s.format {
+	case outputFlat:
+		writeFlatMeasured(s.w, result, s.filter)
+		return nil
+	case outputHTML:
+		err = writeHTML(s.w, result, s.filter, s.resolve)
+	case outputPB:
+		err = writePB(s.w, anon.result(s.filter.result(result)))
+	case outputJSON:
+		err = writeResult(s.w, anon.result(s.filter.result(result)))
+	default:
+		return nil
+	}
+	// 完整结果写完就关闭文件，gzip 尾部和写盘的错误也在这里报告
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *fileSink) Close() error {
+	if s.w == nil {
+		return nil
+	}
+	w := s.w
+	s.w = nil
+	return w.Close()
+}
+
+// fileOutput 是 --output 中的一个“格式=文件”
+type fileOutput struct {
+	format string
+	path   string
+}
+
+// outputFlag 是可以重复指定的 --output 选项：不带文件名的值选择终端上的格式，
+// “格式=文件”另外把结果写进文件，可以写多个
+type outputFlag struct {
+	mode  string // 终端上的格式
+	files []fileOutput
+}
+
+func (o *outputFlag) String() string { return o.mode }
+
+func (o *outputFlag) Set(v string) error {
+	format, path, ok := strings.Cut(v, "=")
+	if !ok {
+		if v != outputTable && v != outputFlat {
+			return fmt.Errorf("未知的输出格式 %q，可选 table 或 flat", v)
+		}
+		o.mode = v
+		return nil
+	}
+	switch format {
+	case outputJSON, outputPB, outputFlat, outputCompat, outputHTML:
+	default:
+		return fmt.Errorf("未知的文件输出格式 %q，可选 json、pb、flat、compat 或 html", format)
+	}
+	if path == "" {
+		return fmt.Errorf("--output %s= 缺少文件名", format)
+	}
+	o.files = append(o.files, fileOutput{format: format, path: path})
+	return nil
+}
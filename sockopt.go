@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// probeConfig 保存探测相关的配置，其中大部分是需要应用到每个探测套接字和监听套接字上的选项
+type probeConfig struct {
+	VRF  string // 绑定到的 VRF 设备名，为空表示使用默认路由表
+	Mark uint32 // 设置到套接字上的防火墙标记（SO_MARK），0 表示不设置
+
+	DontFragment bool // 是否给探测包设置 DF 标志且忽略缓存的路径MTU，用于逐跳MTU探测
+
+	VerifyResponders bool // 是否丢弃地址不可能是真实路由器的回应
+	DebugPackets     bool // 是否把收发的每个报文以十六进制转储到标准错误
+
+	TraceID uint32 // 写进每个探测包负载的 trace 标识，见 stampProbeID
+	RawSend bool   // 是否自己构造IP头和UDP头发送探测包，见 rawSender
+
+	PacketCapture bool // 是否用 AF_PACKET 接收ICMP回应，见 packetCapture
+
+	// 以下两项只在 RawSend 时有效
+	RawSource   net.IP // 写进IP头的源地址，为 nil 时使用内核选择的出口地址
+	UDPChecksum string // UDP校验和的填法，见 checksumModes
+}
+
+// listenPacket 按照 cfg 中的套接字选项创建一个 PacketConn。
+// 所有收发套接字都应该通过它创建，保证选项被一致地应用。
+func (cfg probeConfig) listenPacket(network, address string) (net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				serr = cfg.apply(fd)
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	return lc.ListenPacket(context.Background(), network, address)
+}
+
+// dialer 返回按照 cfg 中的套接字选项建立连接的 Dialer
+func (cfg probeConfig) dialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				serr = cfg.apply(fd)
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+}
+
+// apply 在套接字绑定之前设置各项选项
+func (cfg probeConfig) apply(fd uintptr) error {
+	if cfg.VRF != "" {
+		if err := bindToDevice(fd, cfg.VRF); err != nil {
+			return err
+		}
+	}
+	// 标记只影响发出的数据包，设置到监听套接字上也无妨
+	if cfg.Mark != 0 {
+		if err := setMark(fd, cfg.Mark); err != nil {
+			return err
+		}
+	}
+	if cfg.DontFragment {
+		if err := setDontFragment(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToDevice 通过 SO_BINDTODEVICE 把套接字绑定到指定设备。
+// 绑定到 VRF 设备后，套接字的收发都会使用该 VRF 的路由表。
+// Linux 5.7 起普通用户也可以设置该选项，因此放弃权限后创建的套接字同样适用。
+func bindToDevice(fd uintptr, device string) error {
+	if err := unix.BindToDevice(int(fd), device); err != nil {
+		return fmt.Errorf("绑定到设备 %s 失败: %w", device, err)
+	}
+	return nil
+}
+
+// setMark 通过 SO_MARK 给套接字发出的数据包打上防火墙标记，
+// 使 `ip rule add fwmark N` 之类的策略路由规则对探测包生效。
+// 该选项需要 CAP_NET_ADMIN。
+func setMark(fd uintptr, mark uint32) error {
+	if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(mark)); err != nil {
+		return fmt.Errorf("设置防火墙标记 %d 失败: %w", mark, err)
+	}
+	return nil
+}
+
+// setDontFragment 把 IP_MTU_DISCOVER 设为 IP_PMTUDISC_PROBE：
+// 发出的包都带 DF 标志，并且内核不会用缓存的路径MTU拦截较大的包，
+// 只有超过出接口MTU时才会在本地返回 EMSGSIZE。
+func setDontFragment(fd uintptr) error {
+	if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE); err != nil {
+		return fmt.Errorf("设置 DF 标志失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// bindToDevice 只在 Linux 上可用
+func bindToDevice(fd uintptr, device string) error {
+	return errors.New("--vrf 仅在 Linux 上可用")
+}
+
+// setMark 只在 Linux 上可用
+func setMark(fd uintptr, mark uint32) error {
+	return errors.New("--fwmark 仅在 Linux 上可用")
+}
+
+// setDontFragment 只在 Linux 上可用
+func setDontFragment(fd uintptr) error {
+	return errors.New("--mtu 仅在 Linux 上可用")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 每跳平均 RTT 的估计方法（--rtt-stat）
+const (
+	rttMean   = "mean"   // 所有回应的算术平均
+	rttMedian = "median" // 中位数，不受少数离群值影响
+	rttTrim   = "trim"   // 去掉最大的 K 个值之后再平均，写作 trim=K
+)
+
+// rttEstimator 决定每跳的平均 RTT 怎么算。本机的一次 GC 停顿或调度延迟
+// 只会让个别 RTT 偏大，不会让它偏小，所以截尾只去掉最大的值。
+type rttEstimator struct {
+	kind string
+	trim int // kind 为 trim 时去掉的最大值个数
+}
+
+// rttStat 是当前使用的估计方法，在 main 和 render 中由 --rtt-stat 设置
+var rttStat = rttEstimator{kind: rttMean}
+
+// parseRTTStat 解析 --rtt-stat：mean、median 或 trim=K
+func parseRTTStat(s string) (rttEstimator, error) {
+	switch kind, arg, hasArg := strings.Cut(s, "="); {
+	case s == rttMean || s == rttMedian:
+		return rttEstimator{kind: s}, nil
+	case kind == rttTrim && hasArg:
+		k, err := strconv.Atoi(arg)
+		if err != nil || k < 1 {
+			return rttEstimator{}, fmt.Errorf("--rtt-stat %q 无效，trim= 后面应是正整数", s)
+		}
+		return rttEstimator{kind: rttTrim, trim: k}, nil
+	}
+	return rttEstimator{}, fmt.Errorf("--rtt-stat %q 无效，可选 mean、median 或 trim=K", s)
+}
+
+func (e rttEstimator) String() string {
+	if e.kind == rttTrim {
+		return fmt.Sprintf("%s=%d", rttTrim, e.trim)
+	}
+	return e.kind
+}
+
+// estimate 按估计方法计算 rtts 的平均值，同时返回被当作离群值去掉的个数。
+// 截尾至少保留一个值。没有回应时返回 0。
+func (e rttEstimator) estimate(rtts []float64) (avg float64, dropped int) {
+	if len(rtts) == 0 {
+		return 0, 0
+	}
+	sorted := slices.Sorted(slices.Values(rtts))
+	switch e.kind {
+	case rttMedian:
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[mid-1] + sorted[mid]) / 2, 0
+		}
+		return sorted[mid], 0
+	case rttTrim:
+		dropped = min(e.trim, len(sorted)-1)
+		sorted = sorted[:len(sorted)-dropped]
+	}
+	var sum float64
+	for _, rtt := range sorted {
+		sum += rtt
+	}
+	return sum / float64(len(sorted)), dropped
+}
+
+// applyRTTStat 用当前的估计方法计算一跳的平均 RTT
+func applyRTTStat(hop *Hop) {
+	hop.RTTAvgMs, hop.RTTDropped = rttStat.estimate(hop.RTTsMs)
+}
+
+// describeAvg 返回 avg 列中的说明，注明估计方法和去掉的离群值个数
+func describeAvg(h *Hop) string {
+	if h.Timeout || len(h.RTTsMs) == 0 {
+		return ""
+	}
+	name := "平均"
+	if rttStat.kind == rttMedian {
+		name = "中位数"
+	}
+	s := fmt.Sprintf("%s %.3f ms", name, h.RTTAvgMs)
+	if h.RTTDropped > 0 {
+		s += fmt.Sprintf("（去掉 %d 个最大值）", h.RTTDropped)
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// statsdMaxPacket 是一个 StatsD 数据报的最大长度，多条指标用换行拼在一起发送，
+// 不超过常见以太网 MTU 下 UDP 负载的安全大小，避免被分片
+const statsdMaxPacket = 1432
+
+// statsdSink 在 trace 完成后把每个目标、每一跳的计时和计数指标发给 StatsD。
+// 普通 StatsD 没有标签，目标和跳数编码在指标名中，例如
+// traceroute.8_8_8_8.hop.3.rtt；DogStatsD 则使用 traceroute.hop.rtt 加上
+// target、ttl 标签，便于在后端按标签聚合。
+type statsdSink struct {
+	conn   net.Conn
+	prefix string
+	tags   bool       // 是否使用 DogStatsD 的标签扩展
+	filter *hopFilter // 逐跳的指标只发出满足 --filter 的跳
+	lines  []string
+}
+
+func newStatsdSink(addr, prefix string, dogstatsd bool, filter *hopFilter) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接 StatsD %s 失败: %w", addr, err)
+	}
+	return &statsdSink{conn: conn, prefix: strings.TrimSuffix(prefix, "."), tags: dogstatsd, filter: filter}, nil
+}
+
+func (s *statsdSink) start(target, destIP string, egress *Egress) {}
+
+func (s *statsdSink) hop(target string, hop *Hop) {}
+
+// complete 发出本次 trace 的指标：
+//   - trace（计数）和 reached、hops（gauge）描述整条路径
+//   - 每一跳的 rtt（计时，每个收到的回应一条）、sent 和 lost（计数），只包括满足 --filter 的跳
+func (s *statsdSink) complete(result *TraceResult) error {
+	target := anon.addr(result.Target)
+	s.lines = s.lines[:0]
+	s.add(target, nil, "trace", "1", "c")
+	reached := "0"
+	if result.Reached {
+		reached = "1"
+	}
+	s.add(target, nil, "reached", reached, "g")
+	s.add(target, nil, "hops", fmt.Sprint(len(result.Hops)), "g")
+	hops := s.filter.result(result).Hops
+	for i := range hops {
+		hop := &hops[i]
+		for _, rtt := range hop.RTTsMs {
+			s.add(target, hop, "rtt", fmt.Sprintf("%.3f", rtt), "ms")
+		}
+		s.add(target, hop, "sent", fmt.Sprint(hop.Sent), "c")
+		s.add(target, hop, "lost", fmt.Sprint(hop.Sent-hop.Received), "c")
+	}
+	return s.flush()
+}
+
+// add 记下一条指标，hop 为 nil 时是整条路径的指标
+func (s *statsdSink) add(target string, hop *Hop, name, value, kind string) {
+	if s.tags {
+		line := fmt.Sprintf("%s.%s:%s|%s|#target:%s", s.prefix, statsdName(hop, name), value, kind, target)
+		if hop != nil {
+			line += fmt.Sprintf(",ttl:%d", hop.TTL)
+		}
+		s.lines = append(s.lines, line)
+		return
+	}
+	// 普通 StatsD 的指标名以点号分层，目标中的点号和冒号要换掉
+	metric := strings.NewReplacer(".", "_", ":", "_").Replace(target)
+	if hop != nil {
+		metric += fmt.Sprintf(".hop.%d", hop.TTL)
+	}
+	s.lines = append(s.lines, fmt.Sprintf("%s.%s.%s:%s|%s", s.prefix, metric, name, value, kind))
+}
+
+// statsdName 返回 DogStatsD 形式下的指标名，逐跳的指标放在 hop. 下
+func statsdName(hop *Hop, name string) string {
+	if hop == nil {
+		return name
+	}
+	return "hop." + name
+}
+
+// flush 把记下的指标按 statsdMaxPacket 拼成尽量少的数据报发出
+func (s *statsdSink) flush() error {
+	var packet strings.Builder
+	for _, line := range s.lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			if _, err := s.conn.Write([]byte(packet.String())); err != nil {
+				return fmt.Errorf("发送 StatsD 指标失败: %w", err)
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() == 0 {
+		return nil
+	}
+	if _, err := s.conn.Write([]byte(packet.String())); err != nil {
+		return fmt.Errorf("发送 StatsD 指标失败: %w", err)
+	}
+	return nil
+}
+
+func (s *statsdSink) Close() error { return s.conn.Close() }
@@ -0,0 +1,19 @@
+package main
+
+import "fmt"
+
+// outputQuiet 不打印逐跳结果，只在最后打印一行结论（--quiet）
+const outputQuiet = "quiet"
+
+// summarize 返回一次 trace 的单行结论：是否到达、跳数、目标的RTT和丢包率
+func summarize(result *TraceResult) string {
+	if result.Loop != nil {
+		return fmt.Sprintf("未到达 %s (%s)：检测到路由环路 %s", describeName(result.Target), anon.addr(result.DestIP), describeLoop(result.Loop))
+	}
+	if !result.Reached || len(result.Hops) == 0 {
+		return fmt.Sprintf("未到达 %s (%s)：%d 跳内没有收到目标的回应", describeName(result.Target), anon.addr(result.DestIP), maxHops)
+	}
+	last := result.Hops[len(result.Hops)-1]
+	return fmt.Sprintf("已到达 %s (%s)：%d 跳，RTT %.3f ms，丢包 %.0f%%",
+		describeName(result.Target), anon.addr(result.DestIP), last.TTL, last.RTTMs, last.LossPct)
+}
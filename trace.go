@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// 定义traceroute过程中的一些参数，可以用 --max-hops、--wait、--port（-m、-w、-p）修改
+var (
+	maxHops  = 30              // 设置最大探测跳数，防止无限循环
+	timeout  = 2 * time.Second // 为每一跳设置2秒的超时时间
+	destPort = 33434           // 选择一个不常用的高位端口作为UDP探测包的目标端口
+	portMode = portFixed       // 目标端口是固定不变还是每个探测包加一，可以用 --port-mode 修改
+)
+
+// 目标端口的选择方式
+const (
+	portFixed     = "fixed"     // 所有探测包都发往 destPort，靠负载中的序号区分
+	portIncrement = "increment" // 传统 traceroute 的做法：第 k 个探测包发往 destPort+k，目标端的工具可以靠端口识别探测包
+)
+
+var portModes = []string{portFixed, portIncrement}
+
+// traceOptions 控制探测过程的可选行为
+type traceOptions struct {
+	Probes     int          // 每一跳发送的探测包数量
+	LBClassify bool         // 是否对出现多个响应者的跳进行负载均衡分类
+	LBProbes   int          // 负载均衡分类时，每组发送的探测包数量
+	FirstTTL   int          // 从第几跳开始探测，小于1时从第1跳开始
+	Columns    []column     // 逐跳输出的列（--columns）
+	Output     string       // 逐跳输出的格式：table、flat、compat 或 quiet
+	NoDNS      bool         // 不做反向解析（-n）
+	IXPs       *ixpTable    // 用于标注 IXP 的交换网段，为 nil 时不标注
+	IPAM       *ipamTable   // 用户提供的 IPAM 前缀，为 nil 时不标注
+	Geo        *geoTable    // 用户提供的地理位置数据（--geo-file），为 nil 时不标注
+	GeoOrigin  *GeoInfo     // 本机的位置，光速一致性检查以它为起点，为 nil 时不检查
+	ASNs       *asnResolver // 用于查询每一跳的起源 AS，为 nil 时不查询
+	VRPs       *vrpTable    // 用于验证起源 AS 的 RPKI 数据，为 nil 时不验证
+	Sinks      []outputSink // 接收每一跳结果的输出，为空时不输出
+	Filter     *hopFilter   // 只输出满足表达式的跳（--filter），为 nil 时全部输出
+	TraceID    uint32       // 写进探测包的 trace 标识，记录在结果中
+	Fallbacks  []fallback   // UDP 探测停滞时依次改用的探测方式（--fallback）
+	Protocol   string       // 不是UDP探测时记在每一跳的 protocol 字段中的协议（-T 或 URL 形式的目标）
+	PTRCheck   bool         // 是否确认每一跳的 PTR 记录能正向解析回原地址
+	Ports      []int        // 每轮探测依次发往的目标端口（--ports），为空时只用 destPort
+	Tunnels    bool         // 是否根据标签栈和回应的 TTL 推断 MPLS 隧道和隐藏的跳（--tunnels）
+	Warmup     bool         // 是否在每一跳正式探测之前先发一个不计入结果的探测包（--warmup）
+}
+
+// runTrace 执行核心探测逻辑：逐步增加TTL值发送UDP探测包，
+// 通过 prober 等待路由器返回的ICMP消息，边探测边打印，并返回结构化结果。
+func runTrace(p prober, target string, destIP net.IP, egress *Egress, opts traceOptions) *TraceResult {
+	result := &TraceResult{
+		SchemaVersion: resultSchemaVersion,
+		TraceID:       fmt.Sprintf("%08x", opts.TraceID),
+		Target:        target,
+		TargetASCII:   asciiTarget(target),
+		DestIP:        destIP.String(),
+		Egress:        egress,
+		StartedAt:     time.Now(),
+		RTTStat:       rttStat.String(),
+	}
+
+	// 使用 --fallback 或者TCP探测时每一跳都记下探测它的协议
+	proto, fallbacks, ports := opts.Protocol, opts.Fallbacks, opts.Ports
+	if len(fallbacks) > 0 {
+		proto = protoUDP
+	}
+	for ttl := max(opts.FirstTTL, 1); ttl <= maxHops; ttl++ {
+		hop := Hop{TTL: ttl, Protocol: proto}
+
+		if opts.Warmup {
+			warmUp(p, ttl, destIP, opts.Probes, ports)
+		}
+		// 发送探测包并等待回应，第一个回应决定这一跳的地址和类型
+		reply := probeHop(p, result, &hop, destIP, opts.Probes, ports)
+		applyRTTStat(&hop)
+		recordEvents(result, &hop, p.drainEvents())
+		if reply == nil {
+			// 超时说明这一跳的路由器没有回应
+			hop.Timeout = true
+			hop.State = hopTimeout
+			emitHop(target, &hop, opts)
+			result.Hops = append(result.Hops, hop)
+
+			// 连续几跳都没有回应，或者到了最大跳数还没有到达目标时，
+			// 换下一种协议，从第一个没有回应的跳开始重新探测
+			if n := trailingTimeouts(result.Hops); len(fallbacks) > 0 && (n >= fallbackStall || ttl == maxHops) {
+				next := fallbacks[0]
+				fallbacks = fallbacks[1:]
+				result.Hops = result.Hops[:len(result.Hops)-n]
+				ttl -= n
+				p, proto = next.p, next.proto
+				// ICMP Echo 没有端口，TCP 探测固定使用 fallbackTCPPort
+				ports = nil
+				if opts.Output == outputTable {
+					fmt.Printf("连续 %d 跳没有回应，改用 %s 从第 %d 跳重新探测\n", n, p.mode(), ttl+1)
+				}
+			}
+			continue // 继续下一次循环，探测下一跳
+		}
+
+		// reply.Peer 是返回ICMP消息的主机IP地址，即当前这一跳的路由器地址
+		hop.Addr = reply.Peer.String()
+		hop.Class = classifyAddr(reply.Peer)
+		hop.IXP = opts.IXPs.lookup(reply.Peer)
+		hop.IPAM = opts.IPAM.lookup(reply.Peer)
+		hop.Geo = opts.Geo.lookup(reply.Peer)
+		checkGeo(&hop, opts.GeoOrigin)
+		if opts.PTRCheck {
+			confirmPTR(&hop)
+		}
+		if opts.ASNs != nil {
+			hop.ASN, hop.Prefix = opts.ASNs.origin(reply.Peer)
+			markASBoundary(result.Hops, &hop)
+			if opts.VRPs != nil && hop.ASN != 0 {
+				hop.RPKI = opts.VRPs.validate(hop.Prefix, hop.ASN)
+			}
+		}
+		hop.RTTMs = float64(reply.RTT) / float64(time.Millisecond)
+		hop.ICMPType = int(reply.Type)
+		hop.ICMPCode = reply.Code
+		hop.ReplyTTL, hop.QuotedTTL, hop.MPLS = reply.ReplyTTL, reply.QuotedTTL, reply.MPLS
+		hop.NextMTU = reply.NextHopMTU
+		hop.State = hopState(reply.Type, reply.Code)
+		if reply.FromTarget {
+			hop.State = hopReached
+		}
+		if opts.Tunnels {
+			classifyTunnel(result.Hops, &hop)
+		}
+		emitHop(target, &hop, opts)
+
+		// 分析ICMP消息的类型，判断当前探测的状态
+		switch {
+		case reply.FromTarget:
+			// ICMP Echo Reply 或 TCP 的 SYN-ACK、RST 同样来自目标本身
+			result.Hops = append(result.Hops, hop)
+			if opts.Output == outputTable {
+				fmt.Println("Traceroute 完成!")
+			}
+			result.Reached = true
+			return result
+		case reply.Type == ipv4.ICMPTypeTimeExceeded:
+			// 类型11: Time Exceeded (超时)
+			// 这是我们期望从中间路由器收到的回复，表示探测包的TTL已耗尽
+			// 用额外的探测包检查这一跳后面是否存在负载均衡。
+			// 分类靠改变UDP目标端口区分流，换用其他协议之后就做不了了
+			if opts.LBClassify && (proto == "" || proto == protoUDP) {
+				hop.LoadBalancing, hop.Responders = classifyLoadBalancing(p, ttl, destIP, opts.LBProbes)
+				if hop.LoadBalancing != "" && opts.Output == outputTable {
+					fmt.Printf("    %s\n", describeLoadBalancing(hop.LoadBalancing, hop.Responders))
+				}
+			}
+			result.Hops = append(result.Hops, hop)
+		case reply.Type == ipv4.ICMPTypeDestinationUnreachable && reply.Code == codeFragNeeded:
+			// 需要分片：探测包在这一跳之后的链路上放不下而被丢弃，并没有到达目标，
+			// 更大的TTL也过不去，探测到此结束
+			result.Hops = append(result.Hops, hop)
+			return result
+		case reply.Type == ipv4.ICMPTypeDestinationUnreachable:
+			// 类型3: Destination Unreachable (目标不可达)
+			// 端口不可达通常是最终目标主机返回的，因为我们的UDP包到达了一个未被监听的端口，
+			// 这标志着traceroute过程的成功结束。其他代码（!H、!N、!X 等）可能来自中间路由器，
+			// 探测包被丢弃了，更大的TTL同样过不去，探测到此结束，但并没有到达目标
+			result.Hops = append(result.Hops, hop)
+			result.Reached = hop.State == hopReached
+			if opts.Output == outputTable {
+				if result.Reached {
+					fmt.Println("Traceroute 完成!")
+				} else {
+					fmt.Println("目标不可达，探测结束")
+				}
+			}
+			return result
+		default:
+			// 其他类型的ICMP包已经随这一跳打印出来，以供分析
+			result.Hops = append(result.Hops, hop)
+		}
+
+		// 探测包在几台路由器之间来回转发时，继续增加TTL只会看到同样的地址，
+		// 确认是环路之后就不必再探测了
+		if result.Loop = detectLoop(result.Hops); result.Loop != nil {
+			for i := len(result.Hops) - len(result.Loop); i < len(result.Hops); i++ {
+				result.Hops[i].State = hopLoop
+			}
+			if opts.Output == outputTable {
+				reportLoop(result.Loop)
+			}
+			return result
+		}
+	}
+	return result
+}
+
+// trailingTimeouts 返回结果末尾连续超时的跳数
+func trailingTimeouts(hops []Hop) int {
+	n := 0
+	for i := len(hops) - 1; i >= 0 && hops[i].Timeout; i-- {
+		n++
+	}
+	return n
+}
+
+// emitHop 把一跳的结果交给所有 sink，被 --filter 过滤掉的跳不输出
+func emitHop(target string, hop *Hop, opts traceOptions) {
+	if !opts.Filter.allows(hop) {
+		return
+	}
+	for _, s := range opts.Sinks {
+		s.hop(target, hop)
+	}
+}
+
+// printHop 按选定的格式打印一跳的结果
+func printHop(target string, hop *Hop, opts traceOptions) {
+	switch opts.Output {
+	case outputQuiet:
+		return
+	case outputFlat:
+		fmt.Println(formatFlat(target, hop))
+		return
+	case outputCompat:
+		fmt.Println(formatCompat(hop, !opts.NoDNS))
+		return
+	}
+	fmt.Println(formatHop(opts.Columns, hop, !opts.NoDNS))
+}
+
+// 一跳内出现丢包时，后续探测之间的等待时间按指数增长
+const (
+	backoffStart = 50 * time.Millisecond
+	backoffMax   = time.Second
+)
+
+// probeHop 向第 hop.TTL 跳发送 n 轮探测包，每轮向 ports 中的每个端口各发一个（ports 为空时只发往 destPort），
+// 统计发送和收到的数量，返回第一个回应。
+// 如果这一跳已经回应过却又出现丢包，很可能是路由器在限制ICMP的发送速率，
+// 此时放慢对这一跳的探测，避免把限速误判为丢包。
+// 探测失败不会中止 trace，错误记在 result 中。
+func probeHop(p prober, result *TraceResult, hop *Hop, destIP net.IP, n int, ports []int) *probeReply {
+	if len(ports) == 0 {
+		ports = []int{destPort}
+	}
+	var first *probeReply
+	var delay time.Duration
+	for i := 0; i < n*len(ports); i++ {
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		port := probePort(hop.TTL, n, i, ports)
+		hop.Sent++
+		sentAt := time.Now()
+		reply, err := p.probe(hop.TTL, &net.UDPAddr{IP: destIP, Port: port}, nil)
+		seq := int(p.lastSeq())
+		if err != nil {
+			result.addError(errProbe, hop.TTL, true, err)
+			hop.Probes = append(hop.Probes, ProbeRecord{Seq: seq, Port: port, Timeout: true, SentAt: sentAt})
+			continue
+		}
+		if reply == nil {
+			hop.Probes = append(hop.Probes, ProbeRecord{Seq: seq, Port: port, Timeout: true, SentAt: sentAt})
+			if hop.Received > 0 {
+				delay = min(max(2*delay, backoffStart), backoffMax)
+			}
+			continue
+		}
+		hop.Received++
+		rtt := float64(reply.RTT) / float64(time.Millisecond)
+		hop.RTTsMs = append(hop.RTTsMs, rtt)
+		hop.Probes = append(hop.Probes, ProbeRecord{
+			Seq:        seq,
+			Port:       port,
+			Addr:       reply.Peer.String(),
+			RTTMs:      rtt,
+			ICMPType:   int(reply.Type),
+			ICMPCode:   reply.Code,
+			SentAt:     reply.SentAt,
+			ReceivedAt: reply.ReceivedAt,
+			RTTNs:      reply.RTT.Nanoseconds(),
+			Iface:      reply.Interface,
+			ReplyTTL:   reply.ReplyTTL,
+			QuotedTTL:  reply.QuotedTTL,
+		})
+		if first == nil {
+			first = reply
+		}
+	}
+	hop.LossPct = 100 * float64(hop.Sent-hop.Received) / float64(hop.Sent)
+	if len(ports) > 1 {
+		hop.Ports = answeredPorts(hop.Probes)
+	}
+	return first
+}
+
+// probePort 返回一跳中第 i 个探测包的目标端口，ports 不能为空
+func probePort(ttl, n, i int, ports []int) int {
+	if portMode == portIncrement {
+		// 按 TTL 和这一跳内的序号算出端口，与传统 traceroute 一样第一跳的第一个探测包发往 destPort
+		return destPort + (ttl-1)*n + i
+	}
+	return ports[i%len(ports)]
+}
+
+// resetter 由在探测之间保留状态的收包方式实现，
+// 长期运行、反复使用同一个 prober 的 peer 在每次 trace 之前调用它，
+// 以免记录无限增长、回绕后的序号对上很早以前的探测包
+type resetter interface {
+	reset()
+}
+
+// warmer 由能发送不做记录的探测包的收包方式实现，
+// 它们会记下每个探测包，以便识别迟到和重复的回应
+type warmer interface {
+	warmUp(ttl int, dest *net.UDPAddr)
+}
+
+// warmUp 向第 ttl 跳发送一个不计入结果的探测包。第一个探测包常常要等
+// 本机解析网关的 ARP、防火墙建立 conntrack 条目、路由器填充转发缓存，
+// 它的 RTT 系统性地偏大，甚至因此超时；先让它走一遍，正式的探测就不受影响。
+// 它发往这一跳第一个正式探测包的端口，走同一条流；回应、超时和错误都被丢弃，
+// 之后才到的回应也不会被当成这一跳的迟到回应。
+func warmUp(p prober, ttl int, destIP net.IP, n int, ports []int) {
+	if len(ports) == 0 {
+		ports = []int{destPort}
+	}
+	dest := &net.UDPAddr{IP: destIP, Port: probePort(ttl, n, 0, ports)}
+	if w, ok := p.(warmer); ok {
+		w.warmUp(ttl, dest)
+		return
+	}
+	p.probe(ttl, dest, nil)
+}
+
+// lossNote 在每跳发送多个探测包时返回附加在行尾的丢包率
+func lossNote(hop *Hop) string {
+	if hop.Sent <= 1 {
+		return ""
+	}
+	return fmt.Sprintf(" 丢包 %.0f%%", hop.LossPct)
+}
+
+// recordEvents 把迟到和重复的回应记到它们真正对应的那一跳上
+func recordEvents(result *TraceResult, current *Hop, events []ReplyEvent) {
+	for _, ev := range events {
+		hop := current
+		if ev.TTL != current.TTL {
+			hop = nil
+			for i := range result.Hops {
+				if result.Hops[i].TTL == ev.TTL {
+					hop = &result.Hops[i]
+				}
+			}
+		}
+		if hop != nil {
+			if ev.Kind == replyLate {
+				hop.Late++
+			} else {
+				hop.Duplicates++
+			}
+		}
+		result.LateReplies = append(result.LateReplies, ev)
+	}
+}
+
+// reportLateReplies 在有迟到或重复回应时打印统计
+func reportLateReplies(result *TraceResult) {
+	if len(result.LateReplies) == 0 {
+		return
+	}
+	late := 0
+	for _, ev := range result.LateReplies {
+		if ev.Kind == replyLate {
+			late++
+		}
+	}
+	fmt.Printf("收到迟到回应 %d 个，重复回应 %d 个:\n", late, len(result.LateReplies)-late)
+	for _, ev := range result.LateReplies {
+		kind := "迟到"
+		if ev.Kind == replyDuplicate {
+			kind = "重复"
+		}
+		fmt.Printf("%2d %-15s %s %.3f ms\n", ev.TTL, anon.addr(ev.Addr), kind, ev.RTTMs)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/net/icmp"
+)
+
+// 常见设备发出报文时使用的初始 TTL，从小到大排列
+var initialTTLs = []int{64, 128, 255}
+
+// hiddenHopsMin 是判定存在隐藏跳的最小跳变。返回路径和去程路径不对称时，
+// 相邻两跳的返回跳数相差一跳很常见，差两跳及以上才比较可信。
+const hiddenHopsMin = 2
+
+// 推断出的 MPLS 隧道类型（--tunnels），分类方法来自 Donnet、Vanaubel 等人关于
+// traceroute 中 MPLS 隧道的系列研究
+const (
+	tunnelExplicit  = "explicit"  // 回应带 RFC 4950 标签栈、LSE-TTL 为 1：隧道中的每个 LSR 都可见，并且报告了标签
+	tunnelImplicit  = "implicit"  // 没有标签栈但引用 TTL 大于 1：LSR 可见，只是不支持 RFC 4950
+	tunnelOpaque    = "opaque"    // 只有出口 LER 带标签栈且 LSE-TTL 大于 1：隧道内部的 LSR 都被隐藏
+	tunnelInvisible = "invisible" // 没有任何标签，只能从返回 TTL 推断出之前有被隐藏的 LSR
+)
+
+// tunnelDescriptions 是各隧道类型在终端上的说明
+var tunnelDescriptions = map[string]string{
+	tunnelExplicit:  "显式 MPLS 隧道",
+	tunnelImplicit:  "隐式 MPLS 隧道",
+	tunnelOpaque:    "不透明 MPLS 隧道",
+	tunnelInvisible: "不可见隧道",
+}
+
+// MPLSLabel 是回应中 RFC 4950 扩展携带的一个标签栈条目
+type MPLSLabel struct {
+	Label int  `json:"label"`       // 标签值
+	TC    int  `json:"tc"`          // 流量类别
+	S     bool `json:"s,omitempty"` // 是否栈底
+	TTL   int  `json:"ttl"`         // 标签的 TTL（LSE-TTL）
+}
+
+// mplsLabels 取出 RFC 4884 扩展中的 MPLS 标签栈，没有时返回 nil
+func mplsLabels(exts []icmp.Extension) []MPLSLabel {
+	var labels []MPLSLabel
+	for _, ext := range exts {
+		if s, ok := ext.(*icmp.MPLSLabelStack); ok {
+			for _, l := range s.Labels {
+				labels = append(labels, MPLSLabel{Label: l.Label, TC: l.TC, S: l.S, TTL: l.TTL})
+			}
+		}
+	}
+	return labels
+}
+
+// initialTTL 推断回应发出时的初始 TTL：不小于剩余值的最小常见值，不知道剩余 TTL 时返回 0
+func initialTTL(replyTTL int) int {
+	if replyTTL <= 0 {
+		return 0
+	}
+	for _, initial := range initialTTLs {
+		if replyTTL <= initial {
+			return initial
+		}
+	}
+	return 0
+}
+
+// returnLength 根据回应到达时剩余的 TTL 推断它在返回路径上经过的跳数：
+// 初始 TTL 与剩余值之差加一。不知道剩余 TTL 时返回 0。
+func returnLength(replyTTL int) int {
+	if initial := initialTTL(replyTTL); initial > 0 {
+		return initial - replyTTL + 1
+	}
+	return 0
+}
+
+// hiddenHopsBefore 推断上一个有回应的跳和 hop 之间隐藏了多少跳（FRPLA，去程与返程路径长度分析）。
+// 不传播 TTL 的 MPLS 隧道或 GRE 隧道里的路由器不会递减IP头的 TTL，
+// 去程的探测包穿过隧道时只消耗一跳，这些路由器在表格中完全看不见；
+// 而回应在返回路径上仍然按真实的跳数递减 TTL（或者在隧道出口处一次性扣除）。
+// 因此相邻两个回应者的返回跳数之差比它们的 TTL 之差大出的部分，就是可能被隐藏的跳数。
+// 前面没有回应过的跳、或者任一方不知道回应的 TTL 时返回 0。
+func hiddenHopsBefore(hops []Hop, hop *Hop) int {
+	cur := returnLength(hop.ReplyTTL)
+	if cur == 0 {
+		return 0
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		prev := &hops[i]
+		if prev.Timeout {
+			continue
+		}
+		base := returnLength(prev.ReplyTTL)
+		if base == 0 {
+			return 0
+		}
+		if hidden := (cur - base) - (hop.TTL - prev.TTL); hidden >= hiddenHopsMin {
+			return hidden
+		}
+		return 0
+	}
+	return 0
+}
+
+// classifyTunnel 在探测到一跳时判断它与 MPLS 隧道的关系，hops 是它之前的各跳：
+//   - 标签栈顶的 LSE-TTL 为 1，说明探测包正是在标签中耗尽 TTL 的，这是显式隧道中的 LSR；
+//   - LSE-TTL 大于 1，说明隧道入口没有把IP的 TTL 复制进标签（初始为 255），
+//     探测包在出口处才耗尽IP的 TTL，隧道内部的 255-LSE-TTL 跳都被隐藏了，这是不透明隧道；
+//   - 没有标签栈而引用 TTL 大于 1，说明在此之前有 引用TTL-1 跳只递减了标签的 TTL，
+//     它们是不支持 RFC 4950 的 LSR，这是隐式隧道；
+//   - 以上都不是而 FRPLA 发现了返回跳数的跳变，是不可见隧道。
+func classifyTunnel(hops []Hop, hop *Hop) {
+	switch {
+	case len(hop.MPLS) > 0 && hop.MPLS[0].TTL <= 1:
+		hop.Tunnel = tunnelExplicit
+	case len(hop.MPLS) > 0:
+		hop.Tunnel = tunnelOpaque
+		hop.HiddenHops = 255 - hop.MPLS[0].TTL
+	case hop.QuotedTTL > 1:
+		hop.Tunnel = tunnelImplicit
+	default:
+		if hop.HiddenHops = hiddenHopsBefore(hops, hop); hop.HiddenHops > 0 {
+			hop.Tunnel = tunnelInvisible
+		}
+	}
+}
+
+// applyRTLA 用 --hop-ping 得到的 Echo Reply 改进对不可见隧道的估计（RTLA，返回 TTL 长度分析）。
+// JunOS 路由器发出 Time Exceeded 时初始 TTL 为 255、Echo Reply 为 64，
+// 作为隧道出口时它的 Time Exceeded 经隧道原路返回、其中的 LSR 不递减IP的 TTL，
+// Echo Reply 则逐跳递减：两者返回跳数之差就是隧道的长度。
+// 只有回应符合这种 <255, 64> 特征的跳才能这样估计，结果代替 FRPLA 的估计。
+func applyRTLA(result *TraceResult) {
+	for i := range result.Hops {
+		hop := &result.Hops[i]
+		if hop.Ping == nil || hop.Ping.ReplyTTL == 0 || hop.Tunnel == tunnelExplicit || hop.Tunnel == tunnelOpaque {
+			continue
+		}
+		if initialTTL(hop.ReplyTTL) != 255 || initialTTL(hop.Ping.ReplyTTL) != 64 {
+			continue
+		}
+		if hidden := returnLength(hop.Ping.ReplyTTL) - returnLength(hop.ReplyTTL); hidden >= hiddenHopsMin {
+			hop.Tunnel, hop.HiddenHops = tunnelInvisible, hidden
+		}
+	}
+}
+
+// describeTunnel 返回一跳在 tunnel 列中的说明
+func describeTunnel(hop *Hop) string {
+	switch hop.Tunnel {
+	case "":
+		return ""
+	case tunnelExplicit:
+		return fmt.Sprintf("[%s，标签 %d]", tunnelDescriptions[hop.Tunnel], hop.MPLS[0].Label)
+	case tunnelImplicit:
+		return fmt.Sprintf("[%s，入口之后第 %d 跳]", tunnelDescriptions[hop.Tunnel], hop.QuotedTTL-1)
+	}
+	return fmt.Sprintf("[%s出口，之前可能隐藏了 %d 跳]", tunnelDescriptions[hop.Tunnel], hop.HiddenHops)
+}
+
+// reportTunnels 汇总推断出的隧道：连续的显式或隐式隧道跳合并成一段，
+// 不透明和不可见隧道只能看到出口，报告出口之前隐藏的跳数
+func reportTunnels(result *TraceResult) {
+	hops := result.Hops
+	header := false
+	for i := 0; i < len(hops); i++ {
+		kind := hops[i].Tunnel
+		if kind == "" {
+			continue
+		}
+		if !header {
+			fmt.Println("推断出的隧道:")
+			header = true
+		}
+		switch kind {
+		case tunnelExplicit, tunnelImplicit:
+			j := i
+			for j+1 < len(hops) && hops[j+1].Tunnel == kind {
+				j++
+			}
+			fmt.Printf("  第 %d-%d 跳: %s\n", hops[i].TTL, hops[j].TTL, tunnelDescriptions[kind])
+			i = j
+		default:
+			fmt.Printf("  第 %d 跳 %s 之前: %s，可能隐藏了 %d 跳\n", hops[i].TTL, anon.addr(hops[i].Addr), tunnelDescriptions[kind], hops[i].HiddenHops)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// parseURLTarget 识别从浏览器复制的 URL 形式的目标，例如 https://example.com:8443/path，
+// 返回其中的主机名和端口：URL 中没有写端口时按协议取默认端口（https 为 443、http 为 80，
+// 其余查 /etc/services）。目标不是 URL 时 ok 为 false。
+func parseURLTarget(target string) (host string, port int, ok bool, err error) {
+	if !strings.Contains(target, "://") {
+		return "", 0, false, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", 0, true, fmt.Errorf("目标 %q 不是有效的 URL: %w", target, err)
+	}
+	if host = u.Hostname(); host == "" {
+		return "", 0, true, fmt.Errorf("URL %q 中没有主机名", target)
+	}
+	if s := u.Port(); s != "" {
+		if port, err = strconv.Atoi(s); err != nil || port < 1 || port > 65535 {
+			return "", 0, true, fmt.Errorf("URL %q 中的端口 %s 超出范围", target, s)
+		}
+		return host, port, true, nil
+	}
+	scheme := strings.ToLower(u.Scheme)
+	switch scheme {
+	case "wss":
+		scheme = "https"
+	case "ws":
+		scheme = "http"
+	}
+	if port, err = net.LookupPort("tcp", scheme); err != nil {
+		return "", 0, true, fmt.Errorf("不知道 URL 协议 %s 的默认端口，请在 URL 中写明端口", u.Scheme)
+	}
+	return host, port, true, nil
+}
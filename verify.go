@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+)
+
+// 丢弃ICMP消息的原因
+const (
+	rejectMalformed    = "malformed"     // 长度不足、校验和错误或无法解析的消息
+	rejectNoQuote      = "no-quote"      // 消息没有引用任何UDP数据报（例如别的程序的 ping 回应）
+	rejectMismatch     = "mismatch"      // 引用的数据报不是发往目标、或者源端口不是我们的
+	rejectUnknownProbe = "unknown-probe" // 序号或目标端口对不上任何已发出的探测包
+	rejectOtherTrace   = "other-trace"   // 负载中的 trace 标识不是本次运行的
+	rejectImplausible  = "implausible"   // 响应者地址不可能是真实的路由器（--verify-responders）
+)
+
+// rejectDescriptions 是各丢弃原因在终端上的说明
+var rejectDescriptions = map[string]string{
+	rejectMalformed:    "格式错误",
+	rejectNoQuote:      "未引用探测包",
+	rejectMismatch:     "地址或端口不符",
+	rejectUnknownProbe: "探测包未知",
+	rejectOtherTrace:   "属于其他 trace",
+	rejectImplausible:  "响应者不可信",
+}
+
+// reservedNet 是 240.0.0.0/4 保留地址段，这里的地址不会出现在真实的路由器上
+var reservedNet = &net.IPNet{IP: net.IPv4(240, 0, 0, 0), Mask: net.CIDRMask(4, 32)}
+
+// plausibleResponder 判断一个回应者是否可能是路径上真实存在的设备。
+// 私有地址在运营商网络中很常见，因此不在此列；这里只排除绝不可能
+// 作为ICMP源地址出现的地址，以及冒充目标主机的“端口不可达”。
+func plausibleResponder(peer, destIP net.IP, t ipv4.ICMPType, code int) bool {
+	switch {
+	case peer.IsUnspecified(), peer.IsMulticast(), peer.Equal(net.IPv4bcast), reservedNet.Contains(peer):
+		return false
+	case peer.IsLoopback() && !destIP.IsLoopback():
+		return false
+	case t == ipv4.ICMPTypeDestinationUnreachable && code == codePortUnreachable && !peer.Equal(destIP):
+		// 端口不可达只应该由目标主机本身发出
+		return false
+	}
+	return true
+}
+
+// reportRejected 打印被丢弃的ICMP消息的统计
+func reportRejected(rejects map[string]int) {
+	if len(rejects) == 0 {
+		return
+	}
+	total := 0
+	var parts []string
+	for reason, n := range rejects {
+		total += n
+		parts = append(parts, fmt.Sprintf("%s %d", rejectDescriptions[reason], n))
+	}
+	sort.Strings(parts)
+	fmt.Printf("丢弃了 %d 个与探测无关或不可信的ICMP消息（%s）\n", total, strings.Join(parts, "，"))
+}
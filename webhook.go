@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookSignatureHeader 是携带 HMAC-SHA256 签名的请求头，
+// 接收端可以用同一个密钥对请求体重新计算签名来校验来源。
+const webhookSignatureHeader = "X-Traceroute-Signature"
+
+// webhookSink 在 trace 完成后把结果 POST 到 --webhook 指定的 URL，只包括满足 --filter 的跳
+type webhookSink struct {
+	url, secret string
+	retries     int
+	filter      *hopFilter
+}
+
+func (s *webhookSink) start(target, destIP string, egress *Egress) {}
+
+func (s *webhookSink) hop(target string, hop *Hop) {}
+
+func (s *webhookSink) complete(result *TraceResult) error {
+	if err := postWebhook(s.url, s.secret, s.retries, anon.result(s.filter.result(result))); err != nil {
+		return fmt.Errorf("投递 webhook 失败: %w", err)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error { return nil }
+
+// postWebhook 将一次完成的 trace 结果以 JSON 形式 POST 到指定的URL。
+// 网络错误、5xx 和 429 响应会按指数退避重试，最多重试 retries 次。
+func postWebhook(url, secret string, retries int, result *TraceResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("序列化结果失败: %w", err)
+	}
+
+	// 如果配置了密钥，就对请求体计算签名，格式与常见的 webhook 服务保持一致
+	var signature string
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = sendWebhook(client, url, signature, body)
+		if err == nil || attempt >= retries {
+			return err
+		}
+		if pe, ok := err.(permanentError); ok {
+			// 4xx 之类的错误重试也不会成功，直接放弃
+			return pe.err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// permanentError 标记不值得重试的 webhook 错误
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+// sendWebhook 执行单次投递
+func sendWebhook(client *http.Client, url, signature string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if signature != "" {
+		req.Header.Set(webhookSignatureHeader, signature)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("服务端返回 %s", resp.Status)
+	default:
+		return permanentError{fmt.Errorf("服务端返回 %s", resp.Status)}
+	}
+}